@@ -25,6 +25,8 @@ const (
 	_GetSummonerByID = "https://na.api.pvp.net/api/lol/%s/v1.4/summoner/%s"
 	_GetMatch        = "https://na.api.pvp.net/api/lol/%s/v2.2/match/%d?includeTimeline=true"
 	_GetMatchHistory = "https://na.api.pvp.net/api/lol/%s/v2.2/matchhistory/%d?beginIndex=%d"
+	_GetChallenger   = "https://na.api.pvp.net/api/lol/%s/v2.5/league/challenger?type=%s"
+	_GetMaster       = "https://na.api.pvp.net/api/lol/%s/v2.5/league/master?type=%s"
 
 	_MaxSummonersPerQuery = 40
 )
@@ -170,6 +172,7 @@ func (c *crawler) fetchResource(url string, dst interface{}) error {
 				err = err2
 				log.Printf("Failed to unmarshal response body. Sleeping and then retrying.")
 			} else {
+				responses.save(url, body)
 				return nil // Success!
 			}
 		} else {
@@ -203,8 +206,64 @@ func (c *crawler) getSummonersByID(ids []int64) (map[string]Summoner, error) {
 	return c.getSummonersHelper(_GetSummonerByID, strings.Join(s, ","))
 }
 
+// Lookup any number of summoners by their summoner name, making as many
+// requests as necessary. Returns all the summoners that were found along with
+// the last error that occurred, if any.
+func (c *crawler) getAllSummoners(names []string) (map[string]Summoner, error) {
+	var err error
+	res := make(map[string]Summoner, len(names))
+
+	for i := 0; i < len(names); i += _MaxSummonersPerQuery {
+		// Create slice of length _MaxSummonersPerQuery or less if there aren't
+		// enough elements remaining.
+		ub := i + _MaxSummonersPerQuery
+		if ub > len(names) {
+			ub = len(names)
+		}
+
+		summoners, err2 := c.getSummoners(names[i:ub])
+		if err2 != nil {
+			err = err2
+			continue
+		}
+
+		for k, v := range summoners {
+			res[k] = v
+		}
+	}
+
+	return res, err
+}
+
+// Lookup any number of summoners by their summoner ID, making as many requests
+// as necessary. Returns all the summoners that were found along with the last
+// error that occurred, if any.
+func (c *crawler) getAllSummonersByID(ids []int64) (map[string]Summoner, error) {
+	var err error
+	res := make(map[string]Summoner, len(ids))
+
+	for i := 0; i < len(ids); i += _MaxSummonersPerQuery {
+		ub := i + _MaxSummonersPerQuery
+		if ub > len(ids) {
+			ub = len(ids)
+		}
+
+		summoners, err2 := c.getSummonersByID(ids[i:ub])
+		if err2 != nil {
+			err = err2
+			continue
+		}
+
+		for k, v := range summoners {
+			res[k] = v
+		}
+	}
+
+	return res, err
+}
+
 func (c *crawler) getSummonersHelper(url, summoners string) (map[string]Summoner, error) {
-	if strings.Count(summoners, ",") > _MaxSummonersPerQuery {
+	if strings.Count(summoners, ",") >= _MaxSummonersPerQuery {
 		return nil, errors.New("exceeded maximum number of summoners per query")
 	}
 
@@ -235,20 +294,16 @@ func (c *crawler) getMatch(id int64) (*MatchDetail, error) {
 	return match, nil
 }
 
-// Lookup match ID history for a summoner.
+// Lookup match history for a summoner.
 // 		id - Summoner ID
 // 		start - begin index to use for fetching games.
-// Returns a slice of at most 15 match IDs or any errors that occurred.
-func (c *crawler) getMatchHistory(id, start int64) ([]int64, error) {
+// Returns a slice of at most 15 match summaries or any errors that occurred.
+func (c *crawler) getMatchHistory(id, start int64) ([]BaseMatchDetail, error) {
 	// There are a lot more fields returned by the API request, however, we
-	// really only care about the MatchID since we'll use it to request the full
-	// details.
-	type matchSummary struct {
-		MatchID int64 // ID of the match
-	}
-
+	// really only care about the fields shared with the match details. They are
+	// enough to decide whether we want the full details or not.
 	type playerHistory struct {
-		Matches []matchSummary
+		Matches []BaseMatchDetail
 	}
 
 	history := &playerHistory{}
@@ -261,10 +316,35 @@ func (c *crawler) getMatchHistory(id, start int64) ([]int64, error) {
 		return nil, err
 	}
 
-	// Pull ID out of each match
-	ids := make([]int64, 0, len(history.Matches))
-	for _, match := range history.Matches {
-		ids = append(ids, match.MatchID)
+	return history.Matches, nil
+}
+
+// Lookup the summoner IDs on a ladder.
+// 		url - _GetChallenger or _GetMaster
+// 		queue - queue type of the ladder (e.g. RANKED_SOLO_5x5)
+// Returns the IDs of all the summoners in the league. Entries for ranked
+// teams are skipped since they are not summoners.
+func (c *crawler) getLadder(url, queue string) ([]int64, error) {
+	type leagueEntry struct {
+		PlayerOrTeamId string // Summoner ID or team ID, depending on the queue
+	}
+
+	type league struct {
+		Entries []leagueEntry
+	}
+
+	res := &league{}
+
+	url = fmt.Sprintf(url, _Region, queue)
+	if err := c.fetchResource(url, res); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		if id, err := strconv.ParseInt(entry.PlayerOrTeamId, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
 	}
 
 	return ids, nil
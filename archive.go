@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// ApiResponse is the raw body of a successful API response. Keeping them
+// means that fields we don't parse yet aren't lost, they can be added to the
+// structs later and the responses reparsed.
+type ApiResponse struct {
+	Id         int64  // Auto-incremented ID
+	Endpoint   string // API path without the resource, e.g. v2.2/match
+	ResourceId string // Last part of the API path, e.g. the match ID
+	Url        string `sql:"type:text"` // Full URL requested, without the API key
+	Fetched    int64  // When the response was received, specified as epoch nanoseconds
+	Body       []byte // Response body, compressed with the blob codec
+}
+
+// responseArchive saves API responses to the database.
+type responseArchive struct {
+	db gorm.DB
+}
+
+// Archive for all API responses, nil unless -archive is set.
+var responses *responseArchive
+
+// Split an API URL into the endpoint and the resource that was requested.
+func splitEndpoint(rawurl string) (string, string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", rawurl
+	}
+
+	dir, resource := path.Split(u.Path)
+	dir = strings.TrimSuffix(dir, "/")
+
+	// Skip the common /api/lol/{region} prefix
+	if parts := strings.SplitN(strings.TrimPrefix(dir, "/"), "/", 4); len(parts) == 4 && parts[0] == "api" {
+		dir = parts[3]
+	}
+
+	return dir, resource
+}
+
+// Save a response body. Does nothing if the archive is nil. Errors are
+// logged rather than returned so that they don't fail the request.
+func (a *responseArchive) save(rawurl string, body []byte) {
+	if a == nil {
+		return
+	}
+
+	endpoint, resource := splitEndpoint(rawurl)
+
+	buf, err := blobs.Compress(body)
+	if err != nil {
+		log.Printf("Unable to compress API response: %s -- %s", rawurl, err.Error())
+		return
+	}
+
+	res := &ApiResponse{
+		Endpoint:   endpoint,
+		ResourceId: resource,
+		Url:        rawurl,
+		Fetched:    time.Now().UnixNano(),
+		Body:       buf,
+	}
+
+	if err := a.db.Create(res).Error; err != nil {
+		log.Printf("Unable to archive API response: %s -- %s", rawurl, err.Error())
+	}
+}
+
+// Rebuild the saved matches, and their normalized rows, from the newest
+// archived response for each match.
+func reparseMatches(db gorm.DB) error {
+	endpoint, _ := splitEndpoint(fmt.Sprintf(_GetMatch, _Region, 0))
+
+	var ids []int64
+	err := db.Model(&ApiResponse{}).Where("endpoint = ?", endpoint).
+		Group("resource_id").Pluck("MAX(id)", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reparsing %d archived matches", len(ids))
+
+	for i, id := range ids {
+		res := &ApiResponse{}
+		if err := db.First(res, id).Error; err != nil {
+			return err
+		}
+
+		body, err := blobs.Decompress(res.Body)
+		if err != nil {
+			return fmt.Errorf("Unable to decompress API response: %d -- %s", id, err.Error())
+		}
+
+		match := &MatchDetail{}
+		if err := json.Unmarshal(body, match); err != nil {
+			log.Printf("Unable to unmarshal archived match: %s -- %s", res.ResourceId, err.Error())
+			continue
+		}
+
+		if err := replaceMatch(db, match); err != nil {
+			return err
+		}
+
+		if (i+1)%1000 == 0 {
+			log.Printf("Reparsed %d of %d matches", i+1, len(ids))
+		}
+	}
+
+	return nil
+}
+
+// Replace a saved match, and its normalized rows, in a single transaction.
+func replaceMatch(db gorm.DB, match *MatchDetail) error {
+	tx := db.Begin()
+
+	err := tx.Where("id = ?", match.Id).Delete(&MarshaledMatchDetail{}).Error
+	for i := 0; err == nil && i < len(model.MatchTables); i++ {
+		err = tx.Where("match_id = ?", match.Id).Delete(model.MatchTables[i]).Error
+	}
+
+	var res *MarshaledMatchDetail
+	if err == nil {
+		res, err = match.Marshal(blobs)
+	}
+	if err == nil {
+		err = tx.Create(res).Error
+	}
+	if err == nil {
+		err = insertNormalizedRows(tx, match)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to replace match: %d -- %s", match.Id, err.Error())
+	}
+
+	return tx.Commit().Error
+}
+
+func reparseCommand(db gorm.DB, args []string) error {
+	return reparseMatches(db)
+}
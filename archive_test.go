@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSplitEndpoint(t *testing.T) {
+	cases := []struct {
+		url, endpoint, resource string
+	}{
+		{fmt.Sprintf(_GetMatch, _Region, 1234), "v2.2/match", "1234"},
+		{fmt.Sprintf(_GetMatchHistory, _Region, 56, 15), "v2.2/matchhistory", "56"},
+		{fmt.Sprintf(_GetSummonerByID, _Region, "1,2"), "v1.4/summoner", "1,2"},
+		{fmt.Sprintf(_GetSummoner, _Region, "name"), "v1.4/summoner/by-name", "name"},
+	}
+
+	for _, c := range cases {
+		endpoint, resource := splitEndpoint(c.url)
+		if endpoint != c.endpoint || resource != c.resource {
+			t.Errorf("Expected %s and %s for %s, got %s and %s", c.endpoint, c.resource, c.url, endpoint, resource)
+		}
+	}
+}
+
+func TestReparse(t *testing.T) {
+	defer func() { responses = nil }()
+	responses = &responseArchive{db}
+
+	// The match was saved before we knew about its participants
+	if err := saveMatch(db, testHookMatch(900, "RANKED_SOLO_5x5")); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"matchId": 900, "queueType": "RANKED_SOLO_5x5", "participants": [
+			{"participantId": 1, "championId": 103, "teamId": 100},
+			{"participantId": 2, "championId": 64, "teamId": 200}]}`)
+	}))
+	defer server.Close()
+
+	c := newCrawler("key", 10, 500, 1)
+
+	var match MatchDetail
+	if err := c.fetchResource(server.URL+"/api/lol/na/v2.2/match/900", &match); err != nil {
+		t.Fatalf("Unable to fetch match: %s", err.Error())
+	}
+
+	var archived ApiResponse
+	if db.Where("endpoint = ? AND resource_id = ?", "v2.2/match", "900").First(&archived).RecordNotFound() {
+		t.Fatal("Expected response to be archived")
+	}
+
+	if err := reparseMatches(db); err != nil {
+		t.Fatalf("Unable to reparse matches: %s", err.Error())
+	}
+
+	var count int
+	db.Model(&MatchParticipant{}).Where("match_id = ?", 900).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 participants after reparsing, got %d", count)
+	}
+}
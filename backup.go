@@ -0,0 +1,259 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/external/github.com/mattn/go-sqlite3"
+)
+
+// Prefix for the names of backups, so that rotation leaves other files alone.
+const _BackupPrefix = "crawlol-"
+
+var (
+	// The password in key=value pairs, which is quoted if it contains spaces
+	_PasswordParam = regexp.MustCompile(`(?:^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+	// Escaped characters in a quoted value
+	_QuotedEscape = regexp.MustCompile(`\\(.)`)
+)
+
+// Copy the SQLite database to path with the online backup API. The copy is
+// made in a single step, which only needs a read transaction on the source, so
+// in WAL mode the crawler can keep writing while it runs. The backup is
+// written next to path and renamed once complete.
+func backupSQLite(db gorm.DB, path string) error {
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+
+	dest, err := sql.Open(_SQLite, tmp)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	ctx := context.Background()
+
+	srcConn, err := db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	err = destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			for {
+				done, err := b.Step(-1)
+				if done {
+					break
+				}
+
+				// Not finished yet, keep going until the whole database is copied
+				if err == nil {
+					continue
+				}
+
+				// Another connection is in the middle of something, try again
+				if e, ok := err.(sqlite3.Error); ok && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked) {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+
+				b.Finish()
+				return err
+			}
+
+			return b.Finish()
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	destConn.Close()
+	dest.Close()
+
+	return os.Rename(tmp, path)
+}
+
+// Dump the PostgreSQL database to path with pg_dump, which takes a consistent
+// snapshot without blocking writers. The custom format is already compressed.
+// The password is passed in the environment rather than on the command line,
+// where any user could see it.
+func backupPostgres(dsn, path string) error {
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+
+	dsn, password := splitPassword(dsn)
+
+	cmd := exec.Command("pg_dump", "--format=custom", "--file="+tmp, dsn)
+	cmd.Stderr = os.Stderr
+
+	if password != "" {
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Remove the password from a PostgreSQL DSN, either a URL or key=value pairs.
+// Returns the DSN without the password and the password.
+func splitPassword(dsn string) (string, string) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil || u.User == nil {
+			return dsn, ""
+		}
+
+		password, _ := u.User.Password()
+		u.User = url.User(u.User.Username())
+
+		return u.String(), password
+	}
+
+	m := _PasswordParam.FindStringSubmatchIndex(dsn)
+	if m == nil {
+		return dsn, ""
+	}
+
+	password := dsn[m[2]:m[3]]
+	if strings.HasPrefix(password, "'") {
+		password = _QuotedEscape.ReplaceAllString(password[1:len(password)-1], "$1")
+	}
+
+	return strings.TrimSpace(dsn[:m[0]] + dsn[m[1]:]), password
+}
+
+// Compress the file at path with gzip, replacing it with path.gz. The
+// compressed file is removed again if it couldn't be written completely.
+func gzipFile(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	w := gzip.NewWriter(out)
+	if _, err = io.Copy(w, in); err == nil {
+		if err = w.Close(); err == nil {
+			err = out.Close()
+		}
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return "", err
+	}
+
+	return path + ".gz", os.Remove(path)
+}
+
+// Delete the oldest backups in dir so that only keep remain. Backups are
+// named by the time they were taken so they sort oldest first.
+func rotateBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, f := range files {
+		if name := f.Name(); strings.HasPrefix(name, _BackupPrefix) && !strings.HasSuffix(name, ".tmp") {
+			backups = append(backups, name)
+		}
+	}
+
+	sort.Strings(backups)
+
+	for len(backups) > keep {
+		log.Printf("Deleting old backup: %s", backups[0])
+
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// Take a consistent snapshot of the database while it is in use. Usage:
+//
+//	backup DIR
+//
+// Snapshots are named by the time they were taken and only the newest
+// -backup-keep are kept.
+func backupCommand(db gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a directory for the backup")
+	}
+
+	dir := args[0]
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	name := _BackupPrefix + time.Now().UTC().Format("20060102-150405.000")
+
+	var path string
+	var err error
+
+	if dbDriver(db) == _Postgres {
+		path = filepath.Join(dir, name+".pgdump")
+		err = backupPostgres(*dbPath, path)
+	} else {
+		path = filepath.Join(dir, name+".db")
+		err = backupSQLite(db, path)
+
+		if err == nil && *backupGzip {
+			path, err = gzipFile(path)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Backed up database to %s", path)
+
+	return rotateBackups(dir, int(*backupKeep))
+}
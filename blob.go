@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// Codec used for all blobs, set up from the flags in main.
+var blobs = model.NewCodec()
+
+// Create a dictionary from the blobs of the most recently saved matches.
+// Deflate can only refer back 32KB so each match contributes an equal share,
+// taken from the start of its timeline where the structure repeats the most.
+func trainDictionary(db gorm.DB, samples int) (*BlobDictionary, error) {
+	const size = 32 * 1024
+
+	var rows []MarshaledMatchDetail
+	if err := db.Order("id desc").Limit(samples).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("no matches to sample")
+	}
+
+	share := size / len(rows)
+
+	var data []byte
+	for _, row := range rows {
+		buf, err := blobs.Decompress(row.Timeline)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buf) > share {
+			buf = buf[:share]
+		}
+		data = append(data, buf...)
+	}
+
+	dict := &BlobDictionary{Data: data, Created: time.Now().UnixNano()}
+	if err := db.Create(dict).Error; err != nil {
+		return nil, err
+	}
+
+	blobs.AddDictionary(*dict)
+
+	return dict, nil
+}
+
+// Rewrite the blobs of every saved match with the current compression method.
+func recompressBlobs(db gorm.DB) error {
+	var ids []int64
+	if err := db.Model(&MarshaledMatchDetail{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		row := &MarshaledMatchDetail{}
+		if err := db.First(row, id).Error; err != nil {
+			return err
+		}
+
+		match, err := row.Unmarshal(blobs)
+		if err != nil {
+			return fmt.Errorf("Unable to unmarshal match details: %d -- %s", id, err.Error())
+		}
+
+		res, err := match.Marshal(blobs)
+		if err != nil {
+			return fmt.Errorf("Unable to marshal match details: %d -- %s", id, err.Error())
+		}
+
+		err = db.Model(row).UpdateColumns(map[string]interface{}{
+			"participant_identities": res.ParticipantIdentities,
+			"participants":           res.Participants,
+			"teams":                  res.Teams,
+			"timeline":               res.Timeline,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("Unable to save match details: %d -- %s", id, err.Error())
+		}
+
+		if (i+1)%1000 == 0 {
+			log.Printf("Recompressed %d of %d matches", i+1, len(ids))
+		}
+	}
+
+	return nil
+}
+
+// Print the number of blobs and their total size for each method.
+func blobStats(db gorm.DB, w io.Writer) error {
+	rows, err := db.Model(&MarshaledMatchDetail{}).Select("timeline").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	sizes := map[string]int{}
+
+	for rows.Next() {
+		var buf []byte
+		if err := rows.Scan(&buf); err != nil {
+			return err
+		}
+
+		method := model.BlobMethod(buf)
+		counts[method]++
+		sizes[method] += len(buf)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, method := range []string{model.CompressNone, model.CompressGzip, model.CompressDict} {
+		fmt.Fprintf(w, "%s\t%d timelines\t%d bytes\n", method, counts[method], sizes[method])
+	}
+
+	return nil
+}
+
+func blobsCommand(db gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected one of stats, train or recompress")
+	}
+
+	switch args[0] {
+	case "stats":
+		return blobStats(db, os.Stdout)
+	case "train":
+		samples := 100
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			samples = n
+		}
+
+		dict, err := trainDictionary(db, samples)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Created dictionary %d, use it with -compress %s", dict.Id, model.CompressDict)
+		return nil
+	case "recompress":
+		return recompressBlobs(db)
+	}
+
+	return fmt.Errorf("unknown blobs command: %s", args[0])
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jcrussell/crawlol/model"
+)
+
+func TestBlobDictionary(t *testing.T) {
+	defer blobs.SetMethod(model.CompressGzip)
+
+	// Having no dictionaries yet isn't an error
+	if err := model.NewCodec().Load(*db.Where("1 = 0")); err != nil {
+		t.Fatalf("Unable to load dictionaries: %s", err.Error())
+	}
+
+	match := testHookMatch(801, "RANKED_SOLO_5x5")
+	if err := json.Unmarshal([]byte(testTimeline), &match.Timeline); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saveMatch(db, match); err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+
+	if _, err := trainDictionary(db, 10); err != nil {
+		t.Fatalf("Unable to train dictionary: %s", err.Error())
+	}
+
+	if err := blobs.SetMethod(model.CompressDict); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recompressBlobs(db); err != nil {
+		t.Fatalf("Unable to recompress: %s", err.Error())
+	}
+
+	row := &MarshaledMatchDetail{}
+	db.First(row, 801)
+	if model.BlobMethod(row.Timeline) != model.CompressDict {
+		t.Fatalf("Expected recompressed blob to use the dictionary, got %s", model.BlobMethod(row.Timeline))
+	}
+
+	out, err := row.Unmarshal(blobs)
+	if err != nil || len(out.Timeline.Frames) != 2 {
+		t.Errorf("Unable to decode recompressed match: %v", err)
+	}
+}
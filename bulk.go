@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/external/github.com/lib/pq"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// Save a batch of matches, and their normalized rows, in a single transaction.
+// Matches that are already saved are skipped rather than failing the batch.
+// Returns the matches that were saved.
+func insertMatches(db gorm.DB, matches []*MatchDetail) ([]*MatchDetail, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	tx := db.Begin()
+
+	var saved []*MatchDetail
+	for _, match := range matches {
+		if hasMatch(*tx, match.Id) {
+			continue
+		}
+
+		if err := insertMatch(tx, match); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		saved = append(saved, match)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// Save a batch of matches on PostgreSQL using COPY, which is much faster than
+// inserting the rows one at a time. The rows are copied into temporary staging
+// tables first so that matches that were already saved, possibly by another
+// crawler, can be skipped.
+func copyMatches(db gorm.DB, matches []*MatchDetail) ([]*MatchDetail, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	byID := make(map[int64]*MatchDetail, len(matches))
+
+	var details, rows []interface{}
+	for _, match := range matches {
+		if byID[match.Id] != nil {
+			continue
+		}
+		byID[match.Id] = match
+
+		res, err := match.Marshal(blobs)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to marshal match details: %d -- %s", match.Id, err.Error())
+		}
+		details = append(details, res)
+
+		rows = append(rows, match.NormalizedRows()...)
+	}
+
+	tx := db.Begin()
+
+	saved, err := copyMatchRows(tx, details, rows)
+	for i := 0; err == nil && i < len(saved); i++ {
+		err = notifyMatch(tx, byID[saved[i]])
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	res := make([]*MatchDetail, 0, len(saved))
+	for _, id := range saved {
+		res = append(res, byID[id])
+	}
+
+	return res, nil
+}
+
+// Copy the match and normalized rows into their tables, skipping matches that
+// already exist. Returns the IDs of the matches that were inserted.
+func copyMatchRows(tx *gorm.DB, details, rows []interface{}) ([]int64, error) {
+	matchTable := tx.NewScope(&MarshaledMatchDetail{}).TableName()
+
+	// Group the normalized rows by the table that they belong to
+	var tables []string
+	byTable := make(map[string][]interface{})
+	for _, table := range model.MatchTables {
+		tables = append(tables, tx.NewScope(table).TableName())
+	}
+	for _, row := range rows {
+		table := tx.NewScope(row).TableName()
+		byTable[table] = append(byTable[table], row)
+	}
+
+	// The staging tables have the same columns as the real ones. Including the
+	// defaults means rows are assigned IDs from the real sequences.
+	for _, table := range append([]string{matchTable}, tables...) {
+		stmt := fmt.Sprintf("CREATE TEMPORARY TABLE staging_%s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", table, table)
+		if err := tx.Exec(stmt).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := copyRows(tx, "staging_"+matchTable, details); err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		if err := copyRows(tx, "staging_"+table, byTable[table]); err != nil {
+			return nil, err
+		}
+	}
+
+	// ON CONFLICT waits for any other crawler inserting the same match to
+	// commit and then skips it, so only one of them inserts its rows. The IDs
+	// that were inserted are kept for inserting the normalized rows.
+	if err := tx.Exec("CREATE TEMPORARY TABLE staging_saved (id bigint) ON COMMIT DROP").Error; err != nil {
+		return nil, err
+	}
+
+	res, err := tx.CommonDB().Query(fmt.Sprintf(`WITH inserted AS (
+			INSERT INTO %[1]s SELECT * FROM staging_%[1]s ON CONFLICT (id) DO NOTHING RETURNING id
+		) INSERT INTO staging_saved SELECT id FROM inserted RETURNING id`, matchTable))
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []int64
+	for res.Next() {
+		var id int64
+		if err := res.Scan(&id); err != nil {
+			res.Close()
+			return nil, err
+		}
+		saved = append(saved, id)
+	}
+	res.Close()
+
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	// Skip the rows of matches that were already saved
+	for _, table := range tables {
+		_, err = tx.CommonDB().Exec(fmt.Sprintf(`INSERT INTO %[1]s SELECT * FROM staging_%[1]s
+			WHERE match_id IN (SELECT id FROM staging_saved)`, table))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return saved, nil
+}
+
+// Stream rows into a table with COPY. All the rows must be pointers to the
+// same model type.
+func copyRows(tx *gorm.DB, table string, rows []interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns, _ := columnValues(tx, rows[0])
+
+	stmt, err := tx.CommonDB().Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		_, values := columnValues(tx, row)
+		if _, err := stmt.Exec(values...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	// Flush the rows
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// Get the column names and values of a model, sorted by column name. The
+// primary key is skipped if it is zero so that it is assigned by the
+// database.
+func columnValues(db *gorm.DB, value interface{}) ([]string, []interface{}) {
+	scope := db.NewScope(value)
+
+	var columns []string
+	fields := make(map[string]interface{})
+
+	for name, field := range scope.Fields() {
+		if !field.IsNormal || (field.IsPrimaryKey && field.IsBlank) {
+			continue
+		}
+
+		columns = append(columns, name)
+		fields[name] = field.Field.Interface()
+	}
+
+	sort.Strings(columns)
+
+	values := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		values = append(values, fields[column])
+	}
+
+	return columns, values
+}
+
+// matchWriter buffers matches and saves them to a store in batches.
+// Hooks are fired for the matches once they have been saved.
+type matchWriter struct {
+	store   store
+	size    int
+	matches []*MatchDetail
+	failed  []int64 // Matches that couldn't be saved, for the caller to retry
+
+	// Queue the participants of the saved matches to be looked up
+	queueSummoners bool
+}
+
+func newMatchWriter(s store, size int) *matchWriter {
+	if size < 1 {
+		size = 1
+	}
+
+	return &matchWriter{store: s, size: size}
+}
+
+// Check whether a match is waiting to be saved.
+func (w *matchWriter) has(id int64) bool {
+	for _, match := range w.matches {
+		if match.Id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add a match to the batch, saving the batch if it is full.
+func (w *matchWriter) add(match *MatchDetail) error {
+	if w.has(match.Id) {
+		return nil
+	}
+
+	w.matches = append(w.matches, match)
+
+	if len(w.matches) < w.size {
+		return nil
+	}
+
+	return w.flush()
+}
+
+// Save all the buffered matches. If the batch can't be saved, the matches are
+// saved one at a time so that one bad match doesn't lose the rest of the
+// batch. The buffer is emptied even if there's an error, the matches that
+// couldn't be saved are added to failed instead.
+func (w *matchWriter) flush() error {
+	matches := w.matches
+	w.matches = nil
+
+	if len(matches) == 0 {
+		return nil
+	}
+
+	saved, err := w.store.saveMatches(matches)
+	var failed []string
+
+	if err != nil {
+		log.Printf("Unable to save batch of matches, saving them one at a time -- %s", err.Error())
+
+		saved = nil
+		for _, match := range matches {
+			res, err := w.store.saveMatches([]*MatchDetail{match})
+			if err != nil {
+				log.Printf("Unable to save match details: %d -- %s", match.Id, err.Error())
+
+				w.failed = append(w.failed, match.Id)
+				failed = append(failed, fmt.Sprint(match.Id))
+				continue
+			}
+
+			saved = append(saved, res...)
+		}
+	}
+
+	if skipped := len(matches) - len(saved) - len(failed); skipped > 0 {
+		log.Printf("Skipped %d matches that were already saved", skipped)
+	}
+
+	for _, match := range saved {
+		matchHooks.fire(match)
+	}
+
+	if w.queueSummoners {
+		var ids []int64
+		for _, match := range saved {
+			for _, identity := range match.ParticipantIdentities {
+				ids = append(ids, identity.Player.SummonerID)
+			}
+		}
+
+		if err := w.store.addPendingSummoners(ids); err != nil {
+			log.Printf("Unable to save pending summoners -- %s", err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Unable to save matches: %s", strings.Join(failed, ","))
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSaveMatches(t *testing.T) {
+	saved, err := insertMatches(db, []*MatchDetail{testHookMatch(300, "RANKED_SOLO_5x5"), testHookMatch(301, "RANKED_SOLO_5x5")})
+	if err != nil {
+		t.Fatalf("Unable to save matches: %s", err.Error())
+	}
+
+	if len(saved) != 2 {
+		t.Errorf("Expected 2 matches to be saved, got %d", len(saved))
+	}
+
+	// Matches that were already saved should be skipped
+	saved, err = insertMatches(db, []*MatchDetail{testHookMatch(301, "RANKED_SOLO_5x5"), testHookMatch(302, "RANKED_SOLO_5x5")})
+	if err != nil {
+		t.Fatalf("Unable to save matches: %s", err.Error())
+	}
+
+	if len(saved) != 1 || saved[0].Id != 302 {
+		t.Errorf("Expected only match 302 to be saved, got %#v", saved)
+	}
+
+	var count int
+	db.Model(&MatchParticipant{}).Where("match_id in (?)", []int64{300, 301, 302}).Count(&count)
+	if count != 3 {
+		t.Errorf("Expected 3 participants, got %d", count)
+	}
+}
+
+func TestCopyMatches(t *testing.T) {
+	dsn := os.Getenv("POSTGRES")
+	if dsn == "" {
+		t.Skip("POSTGRES is not set")
+	}
+
+	pg, err := openDB(dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer pg.Close()
+
+	ids := []int64{320, 321}
+	pg.Where("id in (?)", ids).Delete(&MarshaledMatchDetail{})
+	pg.Where("match_id in (?)", ids).Delete(&MatchParticipant{})
+
+	for i := 0; i < 2; i++ {
+		saved, err := copyMatches(pg, []*MatchDetail{testHookMatch(320, "RANKED_SOLO_5x5"), testHookMatch(321, "RANKED_SOLO_5x5")})
+		if err != nil {
+			t.Fatalf("Unable to copy matches: %s", err.Error())
+		}
+
+		// The second time around, the matches have already been saved
+		if expected := 2 - 2*i; len(saved) != expected {
+			t.Errorf("Expected %d matches to be saved, got %d", expected, len(saved))
+		}
+	}
+
+	var count int
+	pg.Model(&MatchParticipant{}).Where("match_id in (?)", ids).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 participants, got %d", count)
+	}
+}
+
+// badStore refuses to save the bad match, failing any batch that contains it.
+type badStore struct {
+	*memStore
+	bad int64
+}
+
+func (s *badStore) saveMatches(matches []*MatchDetail) ([]*MatchDetail, error) {
+	for _, match := range matches {
+		if match.Id == s.bad {
+			return nil, errors.New("bad match")
+		}
+	}
+
+	return s.memStore.saveMatches(matches)
+}
+
+func TestMatchWriterFallback(t *testing.T) {
+	s := &badStore{newMemStore(), 11}
+	w := newMatchWriter(s, 3)
+
+	for _, id := range []int64{10, 11, 12} {
+		w.add(testHookMatch(id, "RANKED_SOLO_5x5"))
+	}
+
+	if !s.hasMatch(10) || !s.hasMatch(12) {
+		t.Error("Expected the good matches in the batch to be saved")
+	}
+
+	if len(w.failed) != 1 || w.failed[0] != 11 {
+		t.Errorf("Expected match 11 to fail, got %v", w.failed)
+	}
+}
+
+func TestMatchWriterQueueSummoners(t *testing.T) {
+	s := &badStore{newMemStore(), 11}
+	w := newMatchWriter(s, 2)
+	w.queueSummoners = true
+
+	for _, id := range []int64{10, 11} {
+		match := testHookMatch(id, "RANKED_SOLO_5x5")
+		match.ParticipantIdentities[0].Player.SummonerID = id * 100
+		w.add(match)
+	}
+
+	// Only the summoners from the match that was saved are queued
+	if !s.pending[1000] || s.pending[1100] {
+		t.Errorf("Expected only summoner 1000 to be queued, got %v", s.pending)
+	}
+}
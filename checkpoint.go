@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// CrawlCheckpoint records how far the crawler got through a summoner's match
+// history so that an interrupted crawl can resume exactly where it stopped.
+// There is at most one checkpoint per summoner and it is removed once the
+// summoner has been crawled.
+type CrawlCheckpoint struct {
+	Id    int64 // Summoner ID
+	Start int64 // Begin index of the history page being processed
+	// JSON-serialized IDs of the new matches from the history page at Start
+	// that haven't been processed yet. Nil if the page hasn't been fetched.
+	Pending []byte
+	// JSON-serialized IDs of the matches from an earlier crawl that couldn't
+	// be saved. They are retried before paging through the history again.
+	Retry []byte
+}
+
+// PendingSummoner is a summoner found in a crawled match that still needs to
+// be looked up and added to the summoner table.
+type PendingSummoner struct {
+	Id int64 // Summoner ID
+}
+
+// Load the checkpoint for a summoner, starting from the beginning of their
+// match history if there isn't one.
+func loadCheckpoint(db gorm.DB, id int64) *CrawlCheckpoint {
+	checkpoint := &CrawlCheckpoint{}
+	if db.First(checkpoint, id).RecordNotFound() {
+		checkpoint = &CrawlCheckpoint{Id: id}
+	}
+
+	return checkpoint
+}
+
+// Get the match IDs still to be processed from the current history page.
+// Returns nil if the page hasn't been fetched yet.
+func (c *CrawlCheckpoint) pending() []int64 {
+	return c.unmarshalIDs(c.Pending)
+}
+
+// Get the match IDs that couldn't be saved by an earlier crawl.
+func (c *CrawlCheckpoint) retry() []int64 {
+	return c.unmarshalIDs(c.Retry)
+}
+
+func (c *CrawlCheckpoint) unmarshalIDs(buf []byte) []int64 {
+	if buf == nil {
+		return nil
+	}
+
+	ids := []int64{}
+	if err := json.Unmarshal(buf, &ids); err != nil {
+		// Start over rather than giving up on the summoner
+		log.Printf("Unable to unmarshal checkpoint for summoner: %d -- %s", c.Id, err.Error())
+		return nil
+	}
+
+	return ids
+}
+
+// Update the match IDs still to be processed from the current history page.
+// Once there are none left, the checkpoint moves on to the next page.
+func (c *CrawlCheckpoint) setPending(ids []int64) {
+	if len(ids) == 0 {
+		c.Start += 15
+		c.Pending = nil
+		return
+	}
+
+	// Marshaling a slice of integers can't fail
+	c.Pending, _ = json.Marshal(ids)
+}
+
+// Keep the matches that couldn't be saved to retry on the next crawl. The
+// summoner's history may have moved on by then, so the next crawl pages
+// through it from the start again.
+func (c *CrawlCheckpoint) setRetry(ids []int64) {
+	c.Start, c.Pending, c.Retry = 0, nil, nil
+
+	if len(ids) > 0 {
+		c.Retry, _ = json.Marshal(ids)
+	}
+}
+
+// Save the checkpoint, creating it if necessary.
+func saveCheckpoint(db gorm.DB, c *CrawlCheckpoint) {
+	var err error
+	if db.First(&CrawlCheckpoint{}, c.Id).RecordNotFound() {
+		err = db.Create(c).Error
+	} else {
+		// Update all the columns since Pending may need to be cleared
+		err = db.Model(c).UpdateColumns(map[string]interface{}{
+			"start":   c.Start,
+			"pending": c.Pending,
+			"retry":   c.Retry,
+		}).Error
+	}
+
+	if err != nil {
+		log.Printf("Unable to save checkpoint for summoner: %d -- %s", c.Id, err.Error())
+	}
+}
+
+// Note summoners that need to be looked up, skipping the ones that we already
+// know about. The summoners are added in a single transaction.
+func addPendingSummoners(db gorm.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	skip := make(map[int64]bool)
+	err := eachChunk(ids, func(chunk []int64) error {
+		var known []int64
+		if err := db.Model(&Summoner{}).Where("id in (?)", chunk).Pluck("id", &known).Error; err != nil {
+			return err
+		}
+
+		var pending []int64
+		if err := db.Model(&PendingSummoner{}).Where("id in (?)", chunk).Pluck("id", &pending).Error; err != nil {
+			return err
+		}
+
+		for _, id := range append(known, pending...) {
+			skip[id] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	for _, id := range ids {
+		if skip[id] {
+			continue
+		}
+		skip[id] = true
+
+		if err := tx.Create(&PendingSummoner{Id: id}).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Unable to save pending summoner: %d -- %s", id, err.Error())
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// Look up all the pending summoners and add them to the summoner table.
+// Summoners stay pending if there was an error looking them up so that they
+// can be retried later.
+func lookupPendingSummoners(s store, c *crawler) {
+	ids, err := s.pendingSummoners()
+	if err != nil {
+		log.Printf("Unable to load pending summoners: %s", err.Error())
+		return
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("Looking up %d new summoners", len(ids))
+
+	res, err := c.getAllSummonersByID(ids)
+	if err != nil {
+		log.Printf("Unable to fetch summoners: %s", err.Error())
+	}
+
+	if err := s.upsertSummoners(res); err != nil {
+		log.Print(err.Error())
+	}
+
+	// Summoners that weren't found without an error don't exist so there's no
+	// point in retrying them.
+	done := ids
+	if err != nil {
+		done = make([]int64, 0, len(res))
+		for _, summoner := range res {
+			done = append(done, summoner.Id)
+		}
+	}
+
+	if len(done) > 0 {
+		if err := s.removePendingSummoners(done); err != nil {
+			log.Printf("Unable to remove pending summoners: %s", err.Error())
+		}
+	}
+}
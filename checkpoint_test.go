@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	checkpoint := loadCheckpoint(db, 42)
+	if checkpoint.Start != 0 || checkpoint.pending() != nil {
+		t.Fatalf("Expected new checkpoint, got %#v", checkpoint)
+	}
+
+	checkpoint.setPending([]int64{1, 2, 3})
+	saveCheckpoint(db, checkpoint)
+
+	checkpoint = loadCheckpoint(db, 42)
+	if got := checkpoint.pending(); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Fatalf("Expected pending matches [1 2 3], got %v", got)
+	}
+
+	// Finishing the page should move on to the next one
+	checkpoint.setPending(nil)
+	saveCheckpoint(db, checkpoint)
+
+	checkpoint = loadCheckpoint(db, 42)
+	if checkpoint.Start != 15 || checkpoint.pending() != nil {
+		t.Fatalf("Expected checkpoint at next page, got %#v", checkpoint)
+	}
+}
+
+func TestAddPendingSummoner(t *testing.T) {
+	if err := db.Create(&Summoner{Id: 51, Name: "Known"}).Error; err != nil {
+		t.Fatalf("Unable to create summoner: %s", err.Error())
+	}
+
+	if err := addPendingSummoners(db, []int64{51, 52, 52}); err != nil {
+		t.Fatalf("Unable to add pending summoners: %s", err.Error())
+	}
+	if err := addPendingSummoners(db, []int64{52}); err != nil {
+		t.Fatalf("Unable to add pending summoners: %s", err.Error())
+	}
+
+	var ids []int64
+	db.Model(&PendingSummoner{}).Where("id in (?)", []int64{51, 52}).Pluck("id", &ids)
+	if !reflect.DeepEqual(ids, []int64{52}) {
+		t.Errorf("Expected only unknown summoner to be pending, got %v", ids)
+	}
+}
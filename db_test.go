@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
 
@@ -20,6 +25,98 @@ func init() {
 	}
 }
 
+func TestDriver(t *testing.T) {
+	if dbDriver(db) != _SQLite {
+		t.Errorf("Expected %s driver, got %s", _SQLite, dbDriver(db))
+	}
+}
+
+func TestSQLiteTuning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileDB, err := openDB(filepath.Join(dir, "crawlol.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer fileDB.Close()
+
+	var mode string
+	fileDB.Raw("PRAGMA journal_mode").Row().Scan(&mode)
+	if mode != "wal" {
+		t.Errorf("Expected WAL journal mode, got %s", mode)
+	}
+
+	// Readers shouldn't be blocked by a write in progress
+	tx := fileDB.Begin()
+	tx.Create(&Summoner{Id: 1, Name: "Writer"})
+
+	var count int
+	if err := fileDB.Model(&Summoner{}).Count(&count).Error; err != nil || count != 0 {
+		t.Errorf("Expected to read 0 committed summoners during write, got %d (%v)", count, err)
+	}
+
+	tx.Commit()
+}
+
+func TestSaveSummoners(t *testing.T) {
+	summoners := map[string]Summoner{}
+	for i := int64(0); i < 40; i++ {
+		name := fmt.Sprintf("Batched%d", i)
+		summoners[name] = Summoner{Id: 3000 + i, Name: name}
+	}
+
+	// A summoner that's already saved should have their profile updated but
+	// keep their crawl state
+	db.Create(&Summoner{Id: 3000, Name: "Batched0", SummonerLevel: 29, LastCrawled: 5, ClaimedBy: "worker"})
+	summoners["Batched0"] = Summoner{Id: 3000, Name: "Batched0", SummonerLevel: 30}
+
+	if err := upsertSummoners(db, summoners); err != nil {
+		t.Fatalf("Unable to save summoners: %s", err.Error())
+	}
+
+	var count int
+	db.Model(&Summoner{}).Where("id >= ? AND id < ?", 3000, 3040).Count(&count)
+	if count != 40 {
+		t.Errorf("Expected 40 summoners, got %d", count)
+	}
+
+	var existing Summoner
+	db.First(&existing, 3000)
+	if existing.LastCrawled != 5 || existing.ClaimedBy != "worker" {
+		t.Errorf("Expected existing summoner to keep their crawl state, got %d, %q", existing.LastCrawled, existing.ClaimedBy)
+	}
+	if existing.SummonerLevel != 30 || existing.Refreshed == 0 {
+		t.Errorf("Expected existing summoner's profile to be updated, got level %d", existing.SummonerLevel)
+	}
+}
+
+func TestRefreshDue(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-48*time.Hour).UnixNano() / int64(time.Millisecond)
+
+	db.Create(&Summoner{Id: 3100, Name: "Stale", RevisionDate: old})
+	db.Create(&Summoner{Id: 3101, Name: "Revised", RevisionDate: now.UnixNano() / int64(time.Millisecond)})
+	db.Create(&Summoner{Id: 3102, Name: "Refreshed", RevisionDate: old, Refreshed: now.UnixNano()})
+
+	ids, err := refreshDue(*db.Where("id >= ? AND id < ?", 3100, 3110), now.Add(-24*time.Hour), 10)
+	if err != nil {
+		t.Fatalf("Unable to find summoners to refresh: %s", err.Error())
+	}
+	if len(ids) != 1 || ids[0] != 3100 {
+		t.Errorf("Expected only the stale summoner to be due, got %v", ids)
+	}
+
+	// Should be marked as refreshed so it isn't returned again
+	ids, _ = refreshDue(*db.Where("id >= ? AND id < ?", 3100, 3110), now.Add(-24*time.Hour), 10)
+	if len(ids) != 0 {
+		t.Errorf("Expected no summoners to be due after refresh, got %v", ids)
+	}
+}
+
 func TestSaveMatch(t *testing.T) {
 	details := &MatchDetail{}
 	details.Id = 0
@@ -30,3 +127,69 @@ func TestSaveMatch(t *testing.T) {
 		t.Fatal("Unable to find saved match details")
 	}
 }
+
+func TestSaveNormalizedMatch(t *testing.T) {
+	details := testHookMatch(500, "RANKED_SOLO_5x5")
+	details.Participants[0].Timeline.Lane = "MIDDLE"
+	details.Participants[0].Runes = []Rune{{RuneID: 5245, Rank: 9}}
+	details.Participants[0].Masteries = []Mastery{{MasteryID: 4111, Rank: 1}, {MasteryID: 4112, Rank: 4}}
+	details.Teams = []Team{
+		{TeamID: 100, Winner: true, DragonKills: 2, Bans: []BannedChampion{{ChampionID: 64, PickTurn: 1}}},
+		{TeamID: 200, Bans: []BannedChampion{{ChampionID: 238, PickTurn: 2}}},
+	}
+
+	if err := saveMatch(db, details); err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+
+	counts := map[interface{}]int{&MatchTeam{}: 2, &MatchBan{}: 2, &MatchRune{}: 1, &MatchMastery{}: 2}
+	for model, expected := range counts {
+		var count int
+		db.Model(model).Where("match_id = ?", 500).Count(&count)
+		if count != expected {
+			t.Errorf("Expected %d rows in %T, got %d", expected, model, count)
+		}
+	}
+
+	// Win rate of a champion in a lane should be a plain query
+	var rate float64
+	row := db.Raw("SELECT AVG(winner) FROM match_participants WHERE champion_id = ? AND lane = ? AND match_id = ?", 103, "MIDDLE", 500).Row()
+	if err := row.Scan(&rate); err != nil || rate != 1 {
+		t.Errorf("Expected win rate of 1, got %v (%v)", rate, err)
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("POSTGRES")
+	if dsn == "" {
+		t.Skip("POSTGRES is not set")
+	}
+
+	pg, err := openDB(dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer pg.Close()
+
+	if dbDriver(pg) != _Postgres {
+		t.Fatalf("Expected %s driver, got %s", _Postgres, dbDriver(pg))
+	}
+
+	details := &MatchDetail{}
+	details.Id = 1560034527
+
+	pg.Delete(&MarshaledMatchDetail{BaseMatchDetail: details.BaseMatchDetail})
+
+	if err := saveMatch(pg, details); err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+
+	if !hasMatch(pg, details.Id) {
+		t.Fatal("Unable to find saved match details")
+	}
+
+	if _, err := claimSummoners(pg, "test", time.Minute, 0, 10, true); err != nil {
+		t.Fatalf("Unable to claim summoners: %s", err.Error())
+	}
+	releaseClaims(pg, "test")
+}
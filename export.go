@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/query"
+)
+
+// Export formats
+const (
+	_ExportCSV     = "csv"
+	_ExportJSONL   = "jsonl"
+	_ExportParquet = "parquet"
+)
+
+// participantExport is a participant flattened with their timeline deltas.
+type participantExport struct {
+	MatchParticipant
+	ParticipantTimeline
+}
+
+// exportTable is a table of rows that can be exported from each match.
+type exportTable struct {
+	name  string                                 // Name of the table, used for the file names
+	proto interface{}                            // Example row, used to find the columns
+	omit  []string                               // Columns to leave out
+	rows  func(match *MatchDetail) []interface{} // Rows for a match
+}
+
+var exportTables = []exportTable{
+	{"matches", &BaseMatchDetail{}, nil, func(match *MatchDetail) []interface{} {
+		return []interface{}{&match.BaseMatchDetail}
+	}},
+	{"participants", &participantExport{}, []string{"id"}, func(match *MatchDetail) []interface{} {
+		var res []interface{}
+		for _, row := range match.NormalizedRows() {
+			if p, ok := row.(*MatchParticipant); ok {
+				// Rows are in the same order as the participants
+				res = append(res, &participantExport{*p, match.Participants[len(res)].Timeline})
+			}
+		}
+		return res
+	}},
+	{"teams", &MatchTeam{}, []string{"id"}, func(match *MatchDetail) []interface{} {
+		return filterRows(match.NormalizedRows(), &MatchTeam{})
+	}},
+	{"events", &MatchEvent{}, []string{"id"}, func(match *MatchDetail) []interface{} {
+		return filterRows(match.TimelineRows(), &MatchEvent{})
+	}},
+}
+
+// Keep only the rows with the same type as model.
+func filterRows(rows []interface{}, model interface{}) []interface{} {
+	var res []interface{}
+	for _, row := range rows {
+		if reflect.TypeOf(row) == reflect.TypeOf(model) {
+			res = append(res, row)
+		}
+	}
+
+	return res
+}
+
+// exportColumn is a column of an exported table, found by flattening the
+// fields of the table's rows.
+type exportColumn struct {
+	name  string
+	index []int // Index of the field for FieldByIndex
+	kind  reflect.Kind
+}
+
+// Find the columns for rows of type t. Embedded structs are flattened into
+// the parent and other structs have their field name as a prefix. Columns
+// with the same name as an earlier column are skipped, as are any fields
+// that aren't basic types.
+func exportColumns(t reflect.Type, prefix string, index []int, omit []string, res []exportColumn) []exportColumn {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		idx := append(append([]int{}, index...), i)
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			p := prefix
+			if !field.Anonymous {
+				p += gorm.ToSnake(field.Name) + "_"
+			}
+			res = exportColumns(field.Type, p, idx, omit, res)
+		case reflect.Bool, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64, reflect.String:
+			name := prefix + gorm.ToSnake(field.Name)
+			if containsString(omit, name) || hasColumn(res, name) {
+				continue
+			}
+
+			res = append(res, exportColumn{name, idx, field.Type.Kind()})
+		}
+	}
+
+	return res
+}
+
+func hasColumn(columns []exportColumn, name string) bool {
+	for _, col := range columns {
+		if col.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the values of the columns from row, as bools, int64s, float64s or
+// strings.
+func exportValues(columns []exportColumn, row interface{}) []interface{} {
+	v := reflect.Indirect(reflect.ValueOf(row))
+
+	res := make([]interface{}, len(columns))
+	for i, col := range columns {
+		f := v.FieldByIndex(col.index)
+
+		switch col.kind {
+		case reflect.Bool:
+			res[i] = f.Bool()
+		case reflect.Float32, reflect.Float64:
+			res[i] = f.Float()
+		case reflect.String:
+			res[i] = f.String()
+		default:
+			res[i] = f.Int()
+		}
+	}
+
+	return res
+}
+
+// rowWriter writes rows of values in one of the export formats.
+type rowWriter interface {
+	write(values []interface{}) error
+	close() error
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, columns []exportColumn) (*csvWriter, error) {
+	res := &csvWriter{csv.NewWriter(w)}
+
+	var header []string
+	for _, col := range columns {
+		header = append(header, col.name)
+	}
+
+	return res, res.w.Write(header)
+}
+
+func (w *csvWriter) write(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case bool:
+			record[i] = strconv.FormatBool(v)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case string:
+			record[i] = v
+		}
+	}
+
+	return w.w.Write(record)
+}
+
+func (w *csvWriter) close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type jsonlWriter struct {
+	w       *bufio.Writer
+	columns []exportColumn
+}
+
+func newJSONLWriter(w io.Writer, columns []exportColumn) *jsonlWriter {
+	return &jsonlWriter{bufio.NewWriter(w), columns}
+}
+
+func (w *jsonlWriter) write(values []interface{}) error {
+	// Write the object by hand so that the keys stay in column order
+	w.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			w.w.WriteByte(',')
+		}
+
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w.w, "%q:", w.columns[i].name)
+		w.w.Write(buf)
+	}
+	w.w.WriteString("}\n")
+
+	return nil
+}
+
+func (w *jsonlWriter) close() error {
+	return w.w.Flush()
+}
+
+// exporter writes the rows of a table to shards of up to -export-shard-size
+// rows each, named TABLE-NNNNN.FORMAT in -export-dir.
+type exporter struct {
+	table   exportTable
+	columns []exportColumn
+	format  string
+	dir     string
+	size    int // Maximum rows per shard, 0 for no limit
+
+	shard int // Number of the current shard
+	rows  int // Rows written to the current shard
+	f     *os.File
+	w     rowWriter
+	total int
+}
+
+func newExporter(table exportTable, format, dir string, size int) *exporter {
+	t := reflect.Indirect(reflect.ValueOf(table.proto)).Type()
+
+	return &exporter{
+		table:   table,
+		columns: exportColumns(t, "", nil, table.omit, nil),
+		format:  format,
+		dir:     dir,
+		size:    size,
+	}
+}
+
+// Start the next shard.
+func (e *exporter) open() error {
+	path := filepath.Join(e.dir, fmt.Sprintf("%s-%05d.%s", e.table.name, e.shard, e.format))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch e.format {
+	case _ExportCSV:
+		e.w, err = newCSVWriter(f, e.columns)
+	case _ExportJSONL:
+		e.w = newJSONLWriter(f, e.columns)
+	case _ExportParquet:
+		e.w = newParquetWriter(f, e.columns)
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	e.f = f
+	e.shard++
+	e.rows = 0
+
+	return nil
+}
+
+func (e *exporter) write(row interface{}) error {
+	if e.f != nil && e.size > 0 && e.rows >= e.size {
+		if err := e.close(); err != nil {
+			return err
+		}
+	}
+
+	if e.f == nil {
+		if err := e.open(); err != nil {
+			return err
+		}
+	}
+
+	e.rows++
+	e.total++
+
+	return e.w.write(exportValues(e.columns, row))
+}
+
+// Finish the current shard, if there is one.
+func (e *exporter) close() error {
+	if e.f == nil {
+		return nil
+	}
+
+	err := e.w.close()
+	if err2 := e.f.Close(); err == nil {
+		err = err2
+	}
+
+	e.f, e.w = nil, nil
+
+	return err
+}
+
+// Find the IDs of the matches to export based on the -export-* filters. The
+// filters are applied to the indexed columns of the matches.
+func exportMatchIDs(db gorm.DB) ([]int64, error) {
+	q := query.New(db)
+
+	if queues := splitList(*exportQueues); len(queues) > 0 {
+		q.Queues(queues...)
+	}
+
+	if seasons := splitList(*exportSeasons); len(seasons) > 0 {
+		q.Seasons(seasons...)
+	}
+
+	q.VersionPrefix(*exportVersion)
+
+	var bounds [2]time.Time
+	for i, value := range []string{*exportSince, *exportUntil} {
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, err
+		}
+
+		bounds[i] = t
+	}
+
+	return q.CreatedBetween(bounds[0], bounds[1]).IDs()
+}
+
+// Export the matches to files for analysis. Usage:
+//
+//	export [matches | participants | teams | events]...
+//
+// All tables are exported if none are given.
+func exportCommand(db gorm.DB, args []string) error {
+	if !containsString([]string{_ExportCSV, _ExportJSONL, _ExportParquet}, *exportFormat) {
+		return fmt.Errorf("unknown export format: %s", *exportFormat)
+	}
+
+	var exporters []*exporter
+	for _, table := range exportTables {
+		if len(args) == 0 || containsString(args, table.name) {
+			exporters = append(exporters, newExporter(table, *exportFormat, *exportDir, int(*exportShardSize)))
+		}
+	}
+
+	if len(exporters) != len(args) && len(args) > 0 {
+		return fmt.Errorf("expected tables from matches, participants, teams or events")
+	}
+
+	if err := os.MkdirAll(*exportDir, 0755); err != nil {
+		return err
+	}
+
+	ids, err := exportMatchIDs(db)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Exporting %d matches", len(ids))
+
+	for i, id := range ids {
+		row := &MarshaledMatchDetail{}
+		if err := db.First(row, id).Error; err != nil {
+			return err
+		}
+
+		match, err := row.Unmarshal(blobs)
+		if err != nil {
+			log.Printf("Unable to unmarshal match details: %d -- %s", id, err.Error())
+			continue
+		}
+
+		for _, e := range exporters {
+			for _, row := range e.table.rows(match) {
+				if err := e.write(row); err != nil {
+					return err
+				}
+			}
+		}
+
+		if (i+1)%1000 == 0 {
+			log.Printf("Exported %d of %d matches", i+1, len(ids))
+		}
+	}
+
+	for _, e := range exporters {
+		if err := e.close(); err != nil {
+			return err
+		}
+
+		log.Printf("Exported %d %s to %d files", e.total, e.table.name, e.shard)
+	}
+
+	return nil
+}
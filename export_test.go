@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExportColumns(t *testing.T) {
+	columns := exportColumns(reflect.TypeOf(participantExport{}), "", nil, []string{"id"}, nil)
+
+	for _, name := range []string{"match_id", "champion_id", "kills", "winner", "gold_per_min_deltas_zero_to_ten"} {
+		if !hasColumn(columns, name) {
+			t.Errorf("Expected participant column %s", name)
+		}
+	}
+
+	if hasColumn(columns, "id") {
+		t.Error("Expected id to be omitted")
+	}
+
+	// Lane is in both the participant and their timeline
+	lanes := 0
+	for _, col := range columns {
+		if col.name == "lane" {
+			lanes++
+		}
+	}
+	if lanes != 1 {
+		t.Errorf("Expected one lane column, got %d", lanes)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := int64(0); i < 3; i++ {
+		match := testHookMatch(1300+i, "RANKED_SOLO_5x5")
+		match.MatchVersion = "9.99.export"
+		match.Participants[0].Timeline.GoldPerMinDeltas.ZeroToTen = 250.5
+		if err := saveMatch(db, match); err != nil {
+			t.Fatalf("Unable to save match: %s", err.Error())
+		}
+	}
+
+	defer func(version, format, dir string, size uint) {
+		*exportVersion, *exportFormat, *exportDir, *exportShardSize = version, format, dir, size
+	}(*exportVersion, *exportFormat, *exportDir, *exportShardSize)
+
+	*exportVersion, *exportDir, *exportShardSize = "9.99", dir, 2
+
+	for _, format := range []string{_ExportCSV, _ExportJSONL, _ExportParquet} {
+		*exportFormat = format
+
+		if err := exportCommand(db, []string{"participants"}); err != nil {
+			t.Fatalf("Unable to export %s: %s", format, err.Error())
+		}
+
+		// Three rows with two per shard
+		if _, err := os.Stat(filepath.Join(dir, "participants-00001."+format)); err != nil {
+			t.Errorf("Expected a second %s shard: %s", format, err.Error())
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, "participants-00000.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected header and two rows, got %d (%v)", len(records), err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "participants-00000.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var row map[string]interface{}
+	if err := json.Unmarshal(bytes.SplitN(buf, []byte("\n"), 2)[0], &row); err != nil {
+		t.Fatalf("Unable to unmarshal exported row: %s", err.Error())
+	}
+	if row["match_id"] != 1300.0 || row["gold_per_min_deltas_zero_to_ten"] != 250.5 {
+		t.Errorf("Unexpected exported row: %v", row)
+	}
+
+	buf, err = ioutil.ReadFile(filepath.Join(dir, "participants-00000.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, columns, groups := readParquet(t, buf)
+	if len(groups) != 1 || groups[0] != 2 {
+		t.Errorf("Expected one row group of two rows, got %v", groups)
+	}
+
+	for i, name := range names {
+		if name == "match_id" && !reflect.DeepEqual(columns[i], []interface{}{int64(1300), int64(1301)}) {
+			t.Errorf("Unexpected match IDs: %v", columns[i])
+		}
+		if name == "gold_per_min_deltas_zero_to_ten" && !reflect.DeepEqual(columns[i], []interface{}{250.5, 250.5}) {
+			t.Errorf("Unexpected gold per minute: %v", columns[i])
+		}
+	}
+}
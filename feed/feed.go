@@ -0,0 +1,104 @@
+// Package feed streams the matches saved by crawlol as they are saved, using
+// PostgreSQL's LISTEN/NOTIFY, so that other services don't have to poll the
+// database for new matches.
+package feed
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/lib/pq"
+)
+
+// DefaultChannel is the channel that crawlol notifies by default.
+const DefaultChannel = "crawlol_matches"
+
+// Event is sent each time that a match is saved. It only contains the key
+// metadata for the match, the full details can be looked up by ID.
+type Event struct {
+	MatchId       int64   // ID of the match
+	MapId         int     // Match map ID
+	MatchCreation int64   // Match creation time
+	MatchDuration int64   // Match duration
+	MatchMode     string  // Match mode
+	MatchType     string  // Match type
+	MatchVersion  string  // Match version
+	QueueType     string  // Match queue type
+	Region        string  // Region where the match was played
+	Season        string  // Season match was played
+	SummonerIds   []int64 // IDs of the summoners that played in the match
+}
+
+// Subscriber receives the events sent on a channel.
+type Subscriber struct {
+	Events <-chan Event // Events, in the order they were sent
+	// Errors from the connection and undecodable notifications. Events may
+	// have been missed while the connection was down.
+	Errors <-chan error
+
+	listener *pq.Listener
+	done     chan struct{} // Closed by Close to stop sending events
+	once     sync.Once
+}
+
+// Subscribe to events on the channel in the PostgreSQL database given by dsn.
+// The connection is re-established automatically if it is lost.
+func Subscribe(dsn, channel string) (*Subscriber, error) {
+	events := make(chan Event, 32)
+	errs := make(chan error, 32)
+	done := make(chan struct{})
+
+	// Drop errors rather than blocking the listener if nobody is reading them
+	report := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			report(err)
+		}
+	})
+
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer close(events)
+
+		for n := range listener.Notify {
+			// A nil notification means that the connection was re-established
+			if n == nil {
+				continue
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				report(err)
+				continue
+			}
+
+			// Nobody may be receiving any more once the subscription is closed
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return &Subscriber{Events: events, Errors: errs, listener: listener, done: done}, nil
+}
+
+// Close the subscription. Events is closed afterwards, any events that haven't
+// been received yet may be discarded.
+func (s *Subscriber) Close() error {
+	s.once.Do(func() { close(s.done) })
+
+	return s.listener.Close()
+}
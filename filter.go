@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fieldFilter restricts the values that a single match field may take.
+type fieldFilter struct {
+	Include []string // Values to allow, all values are allowed if empty
+	Exclude []string // Values to reject, takes precedence over Include
+}
+
+// matchFilter decides which matches are worth fetching and storing. Only
+// fields that are present in both the match history summaries and the full
+// match details are used so that the filter can be applied before spending
+// any of the rate limit on a match.
+type matchFilter struct {
+	QueueType fieldFilter
+	MatchType fieldFilter
+	MapId     fieldFilter
+	Season    fieldFilter
+	MatchMode fieldFilter
+}
+
+func newFieldFilter(include, exclude string) fieldFilter {
+	return fieldFilter{Include: splitList(include), Exclude: splitList(exclude)}
+}
+
+// Check whether the value passes the filter. Values are compared case
+// insensitively.
+func (f fieldFilter) allows(v string) bool {
+	for _, e := range f.Exclude {
+		if strings.EqualFold(e, v) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	for _, i := range f.Include {
+		if strings.EqualFold(i, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check whether the match passes all the field filters. A nil filter allows
+// every match.
+func (f *matchFilter) allows(m *BaseMatchDetail) bool {
+	if f == nil {
+		return true
+	}
+
+	return f.QueueType.allows(m.QueueType) &&
+		f.MatchType.allows(m.MatchType) &&
+		f.MapId.allows(strconv.Itoa(m.MapId)) &&
+		f.Season.allows(m.Season) &&
+		f.MatchMode.allows(m.MatchMode)
+}
+
+// Split a comma-separated list into its elements, dropping surrounding
+// whitespace and empty elements.
+func splitList(s string) []string {
+	var res []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
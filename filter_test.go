@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestMatchFilter(t *testing.T) {
+	filter := &matchFilter{
+		QueueType: newFieldFilter("RANKED_SOLO_5x5, RANKED_TEAM_5x5", ""),
+		MapId:     newFieldFilter("11", ""),
+		Season:    newFieldFilter("", "preseason2015"),
+	}
+
+	tests := []struct {
+		match   BaseMatchDetail
+		allowed bool
+	}{
+		{BaseMatchDetail{QueueType: "RANKED_SOLO_5x5", MapId: 11, Season: "SEASON2015"}, true},
+		{BaseMatchDetail{QueueType: "RANKED_TEAM_5x5", MapId: 11, Season: "SEASON2014"}, true},
+		{BaseMatchDetail{QueueType: "NORMAL_5x5_BLIND", MapId: 11, Season: "SEASON2015"}, false},
+		{BaseMatchDetail{QueueType: "RANKED_SOLO_5x5", MapId: 10, Season: "SEASON2015"}, false},
+		{BaseMatchDetail{QueueType: "RANKED_SOLO_5x5", MapId: 11, Season: "PRESEASON2015"}, false},
+	}
+
+	for i, test := range tests {
+		if got := filter.allows(&test.match); got != test.allowed {
+			t.Errorf("Test %d: expected allows = %t, got %t", i, test.allowed, got)
+		}
+	}
+
+	// A nil filter should allow everything
+	var none *matchFilter
+	if !none.allows(&tests[2].match) {
+		t.Error("Expected nil filter to allow all matches")
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// QuarantinedMatch is a saved match that couldn't be used, moved out of the
+// way by fsck so that it can be fetched again. The blobs are kept as they
+// were in case they can be recovered by hand.
+type QuarantinedMatch struct {
+	Id          int64  // ID of the match
+	Reason      string `sql:"type:text"` // Why the match was quarantined
+	Quarantined int64  // Time the match was quarantined, specified as epoch nanoseconds
+
+	// Blobs from the MarshaledMatchDetail
+	ParticipantIdentities, Participants, Teams, Timeline []byte
+}
+
+// PendingMatch is a match that needs to be fetched again by the crawler.
+// Matches that still can't be fetched after _MaxRefetchAttempts are parked,
+// they stay in the table to be looked into but aren't fetched again.
+type PendingMatch struct {
+	Id       int64 // Match ID
+	Attempts int   // Number of failed attempts at fetching the match again
+}
+
+// Number of times that fetching a pending match again can fail before the
+// match is parked.
+const _MaxRefetchAttempts = 5
+
+// fsckReport is the problems found by checkDatabase.
+type fsckReport struct {
+	matches          int              // Matches checked
+	corrupt          map[int64]string // Matches that couldn't be decoded, with the error
+	empty            []int64          // Matches without any participants
+	unnormalized     []int64          // Matches with participants but no participant rows
+	missingSummoners []int64          // Summoners in matches but not in the summoner table
+}
+
+// Check every saved match and the summoners that played in them.
+func checkDatabase(db gorm.DB) (*fsckReport, error) {
+	report := &fsckReport{corrupt: map[int64]string{}}
+
+	var ids []int64
+	if err := db.Model(&MarshaledMatchDetail{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	var normalized []int64
+	if err := db.Model(&MatchParticipant{}).Pluck("DISTINCT match_id", &normalized).Error; err != nil {
+		return nil, err
+	}
+
+	hasRows := make(map[int64]bool, len(normalized))
+	for _, id := range normalized {
+		hasRows[id] = true
+	}
+
+	for i, id := range ids {
+		report.matches++
+
+		row := &MarshaledMatchDetail{}
+		if err := db.First(row, id).Error; err != nil {
+			return nil, err
+		}
+
+		match, err := row.Unmarshal(blobs)
+		switch {
+		case err != nil:
+			report.corrupt[id] = err.Error()
+		case len(match.Participants) == 0:
+			report.empty = append(report.empty, id)
+		case !hasRows[id]:
+			report.unnormalized = append(report.unnormalized, id)
+		}
+
+		if (i+1)%10000 == 0 {
+			log.Printf("Checked %d of %d matches", i+1, len(ids))
+		}
+	}
+
+	rows, err := db.Raw(`SELECT DISTINCT summoner_id FROM match_participants
+		WHERE summoner_id <> 0 AND summoner_id NOT IN (SELECT id FROM summoners)`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		report.missingSummoners = append(report.missingSummoners, id)
+	}
+
+	return report, rows.Err()
+}
+
+func (r *fsckReport) print(w io.Writer) {
+	fmt.Fprintf(w, "%d matches checked\n", r.matches)
+
+	corrupt := make([]int64, 0, len(r.corrupt))
+	for id := range r.corrupt {
+		corrupt = append(corrupt, id)
+	}
+	sort.Sort(int64s(corrupt))
+
+	fmt.Fprintf(w, "%d corrupt matches\n", len(corrupt))
+	for _, id := range corrupt {
+		fmt.Fprintf(w, "\t%d\t%s\n", id, r.corrupt[id])
+	}
+
+	fmt.Fprintf(w, "%d matches without participants\n", len(r.empty))
+	for _, id := range r.empty {
+		fmt.Fprintf(w, "\t%d\n", id)
+	}
+
+	fmt.Fprintf(w, "%d matches without participant rows\n", len(r.unnormalized))
+	fmt.Fprintf(w, "%d summoners missing from the summoner table\n", len(r.missingSummoners))
+}
+
+// Fix the problems found by checkDatabase. Corrupt and empty matches are
+// quarantined and queued to be fetched again, matches without participant
+// rows are normalized again and missing summoners are queued to be looked up.
+func repairDatabase(db gorm.DB, report *fsckReport) error {
+	for id, reason := range report.corrupt {
+		if err := quarantineMatch(db, id, reason); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range report.empty {
+		if err := quarantineMatch(db, id, "no participants"); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range report.unnormalized {
+		row := &MarshaledMatchDetail{}
+		if err := db.First(row, id).Error; err != nil {
+			return err
+		}
+
+		match, err := row.Unmarshal(blobs)
+		if err != nil {
+			return err
+		}
+
+		if err := replaceMatch(db, match); err != nil {
+			return err
+		}
+	}
+
+	return addPendingSummoners(db, report.missingSummoners)
+}
+
+// Move a match and its normalized rows out of the way and queue it to be
+// fetched again.
+func quarantineMatch(db gorm.DB, id int64, reason string) error {
+	row := &MarshaledMatchDetail{}
+	if err := db.First(row, id).Error; err != nil {
+		return err
+	}
+
+	q := &QuarantinedMatch{
+		Id:                    id,
+		Reason:                reason,
+		Quarantined:           time.Now().UnixNano(),
+		ParticipantIdentities: row.ParticipantIdentities,
+		Participants:          row.Participants,
+		Teams:                 row.Teams,
+		Timeline:              row.Timeline,
+	}
+
+	tx := db.Begin()
+
+	// Quarantining the same match twice keeps the newest copy
+	err := tx.Where("id = ?", id).Delete(&QuarantinedMatch{}).Error
+	if err == nil {
+		err = tx.Create(q).Error
+	}
+	if err == nil {
+		err = tx.Where("id = ?", id).Delete(&MarshaledMatchDetail{}).Error
+	}
+	for i := 0; err == nil && i < len(model.MatchTables); i++ {
+		err = tx.Where("match_id = ?", id).Delete(model.MatchTables[i]).Error
+	}
+	if err == nil {
+		err = addPendingMatches(tx, []int64{id})
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to quarantine match: %d -- %s", id, err.Error())
+	}
+
+	log.Printf("Quarantined match %d: %s", id, reason)
+
+	return tx.Commit().Error
+}
+
+// Queue matches to be fetched again, skipping any that are queued already.
+func addPendingMatches(tx *gorm.DB, ids []int64) error {
+	for _, id := range ids {
+		if !tx.First(&PendingMatch{}, id).RecordNotFound() {
+			continue
+		}
+
+		if err := tx.Create(&PendingMatch{Id: id}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fetch the matches that were queued by fsck again. Matches stay pending if
+// there was an error fetching or saving them, or the fetched match is no more
+// usable than the one that was quarantined, so that they can be retried later.
+// Each of those counts as a failed attempt towards parking the match.
+func refetchPendingMatches(s store, c *crawler) {
+	ids, err := s.pendingMatches()
+	if err != nil {
+		log.Printf("Unable to load pending matches: %s", err.Error())
+		return
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("Fetching %d quarantined matches again", len(ids))
+
+	w := newMatchWriter(s, int(*writeBatch))
+
+	var fetched, failed []int64
+	for _, id := range ids {
+		match, err := c.getMatch(id)
+		if err != nil {
+			log.Printf("Unable to fetch match details: %d -- %s", id, err.Error())
+			failed = append(failed, id)
+			continue
+		}
+
+		// Saving a match that would just be quarantined again would only go
+		// round in circles
+		if err := validateMatch(match); err != nil {
+			log.Printf("Fetched match is still unusable: %d -- %s", id, err.Error())
+			failed = append(failed, id)
+			continue
+		}
+
+		fetched = append(fetched, id)
+
+		// The writer keeps the matches that it couldn't save in failed
+		if err := w.add(match); err != nil {
+			log.Print(err.Error())
+		}
+	}
+
+	if err := w.flush(); err != nil {
+		log.Print(err.Error())
+	}
+
+	// Only the matches that were actually saved are done
+	var done []int64
+	for _, id := range fetched {
+		if !containsInt64(w.failed, id) {
+			done = append(done, id)
+		}
+	}
+	failed = append(failed, w.failed...)
+
+	if len(done) > 0 {
+		if err := s.removePendingMatches(done); err != nil {
+			log.Printf("Unable to remove pending matches: %s", err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		log.Printf("Unable to fetch %d matches again, they are parked after %d attempts", len(failed), _MaxRefetchAttempts)
+
+		if err := s.failPendingMatches(failed); err != nil {
+			log.Printf("Unable to record failed attempts for pending matches: %s", err.Error())
+		}
+	}
+}
+
+// Check the database for problems. Usage:
+//
+//	fsck [repair]
+//
+// With repair, the problems are also fixed, see repairDatabase.
+func fsckCommand(db gorm.DB, args []string) error {
+	repair := len(args) == 1 && args[0] == "repair"
+	if len(args) > 0 && !repair {
+		return errors.New("expected no arguments or repair")
+	}
+
+	report, err := checkDatabase(db)
+	if err != nil {
+		return err
+	}
+
+	report.print(os.Stdout)
+
+	if !repair {
+		return nil
+	}
+
+	return repairDatabase(db, report)
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
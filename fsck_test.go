@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	// Corrupt blob
+	db.Create(&MarshaledMatchDetail{
+		BaseMatchDetail: BaseMatchDetail{Id: 1600},
+		Participants:    []byte("{not json"),
+	})
+
+	// No participants at all
+	empty := testHookMatch(1601, "RANKED_SOLO_5x5")
+	empty.Participants = nil
+	saveMatch(db, empty)
+
+	// Participants that were never normalized, played by an unknown summoner
+	unnormalized := testHookMatch(1602, "RANKED_SOLO_5x5")
+	unnormalized.ParticipantIdentities[0].Player.SummonerID = 3400
+	saveMatch(db, unnormalized)
+	db.Where("match_id = ?", 1602).Delete(&MatchParticipant{})
+
+	missing := testHookMatch(1603, "RANKED_SOLO_5x5")
+	missing.ParticipantIdentities[0].Player.SummonerID = 3401
+	saveMatch(db, missing)
+
+	report, err := checkDatabase(db)
+	if err != nil {
+		t.Fatalf("Unable to check database: %s", err.Error())
+	}
+
+	if _, ok := report.corrupt[1600]; !ok {
+		t.Error("Expected match 1600 to be corrupt")
+	}
+	if !containsInt64(report.empty, 1601) {
+		t.Error("Expected match 1601 to have no participants")
+	}
+	if !containsInt64(report.unnormalized, 1602) {
+		t.Error("Expected match 1602 to have no participant rows")
+	}
+	if !containsInt64(report.missingSummoners, 3401) {
+		t.Error("Expected summoner 3401 to be missing")
+	}
+
+	// Only repair the matches from this test, the others belong to other tests
+	err = repairDatabase(db, &fsckReport{
+		corrupt:          map[int64]string{1600: report.corrupt[1600]},
+		empty:            []int64{1601},
+		unnormalized:     []int64{1602},
+		missingSummoners: []int64{3401},
+	})
+	if err != nil {
+		t.Fatalf("Unable to repair database: %s", err.Error())
+	}
+
+	for _, id := range []int64{1600, 1601} {
+		if hasMatch(db, id) {
+			t.Errorf("Expected match %d to be removed", id)
+		}
+		if db.First(&QuarantinedMatch{}, id).RecordNotFound() {
+			t.Errorf("Expected match %d to be quarantined", id)
+		}
+	}
+
+	ids, err := newSQLStore(db).pendingMatches()
+	if err != nil || !containsInt64(ids, 1600) || !containsInt64(ids, 1601) {
+		t.Errorf("Expected quarantined matches to be fetched again, got %v (%v)", ids, err)
+	}
+
+	var count int
+	db.Model(&MatchParticipant{}).Where("match_id = ?", 1602).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected match 1602 to be normalized again, got %d participants", count)
+	}
+
+	if db.First(&PendingSummoner{}, 3401).RecordNotFound() {
+		t.Error("Expected missing summoner to be queued")
+	}
+}
+
+func TestRefetchPendingMatches(t *testing.T) {
+	participants := `"participants": [{"participantId": 1}], "participantIdentities": [{"participantId": 1}]`
+
+	c := newCrawler("key", 100, 100, 1)
+	c.Client = &http.Client{Transport: fakeAPI{
+		"/api/lol/na/v2.2/match/20": `{"matchId": 20, ` + participants + `}`,
+		"/api/lol/na/v2.2/match/21": `{"matchId": 21, ` + participants + `}`,
+		"/api/lol/na/v2.2/match/23": `{"matchId": 23}`,
+	}}
+
+	s := &badStore{newMemStore(), 21}
+	s.addPendingMatches([]int64{20, 21, 22, 23})
+
+	refetchPendingMatches(s, c)
+
+	if !s.hasMatch(20) || s.hasMatch(21) || s.hasMatch(23) {
+		t.Error("Expected only match 20 to be saved")
+	}
+
+	// Matches that couldn't be fetched or saved, or that are still empty, stay
+	// pending
+	if len(s.refetch) != 3 || s.refetch[21] != 1 || s.refetch[22] != 1 || s.refetch[23] != 1 {
+		t.Errorf("Expected matches 21, 22 and 23 to stay pending with one attempt, got %v", s.refetch)
+	}
+
+	// Until they are parked
+	for i := 1; i < _MaxRefetchAttempts; i++ {
+		refetchPendingMatches(s, c)
+	}
+
+	if ids, _ := s.pendingMatches(); len(ids) != 0 || len(s.refetch) != 3 {
+		t.Errorf("Expected the failed matches to be parked, got %v pending of %v", ids, s.refetch)
+	}
+}
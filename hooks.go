@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// Maximum time to wait for a webhook or command to complete.
+const _HookTimeout = time.Minute
+
+// Number of saved matches that can be waiting for the hooks. Matches saved
+// while the queue is full are dead-lettered instead, up to the same number
+// waiting at once. Any more than that are only counted and logged.
+const _HookQueueSize = 100
+
+// Maximum time to wait for queued matches to be delivered at shutdown. Any
+// still waiting afterwards are dead-lettered.
+const _HookDrainTimeout = 30 * time.Second
+
+// hook is an action to take after a match is saved. Exactly one of URL and
+// Command should be set. Hooks are loaded from a JSON file containing a list
+// of hooks.
+type hook struct {
+	URL       string      // Webhook to POST a JSON summary of the match to
+	Command   []string    // Command and arguments to run with the match JSON on stdin
+	Summoners []int64     // Only fire when one of these summoners participated
+	Watched   bool        // Only fire when a summoner on the watchlist participated
+	Ranked    bool        // Only fire for matches in ranked queues
+	Filter    matchFilter // Only fire for matches that pass this filter
+}
+
+// hookSummary is the body POSTed to webhooks.
+type hookSummary struct {
+	BaseMatchDetail
+	Participants []hookParticipant
+}
+
+type hookParticipant struct {
+	SummonerID   int64  // Summoner ID
+	SummonerName string // Summoner name
+	ChampionID   int    // Champion ID
+	TeamID       int    // Team ID
+	Winner       bool   // Flag indicating whether or not the participant won
+}
+
+// FailedDelivery is a dead letter for a hook that could not be delivered
+// after all its retries. They can be redelivered with the hooks command.
+type FailedDelivery struct {
+	Id       int64  // Auto-incremented ID
+	MatchId  int64  // ID of the match the delivery was for
+	Hook     []byte // JSON-serialized hook that failed
+	Payload  []byte // Body that was sent to the hook
+	Error    string `sql:"type:text"` // Error from the last attempt
+	Attempts int    // Number of attempts made
+	Failed   int64  // Time of the last attempt, specified as epoch nanoseconds
+}
+
+// hookDispatcher delivers saved matches to hooks in the background so that
+// slow or failing hooks don't hold up the crawler.
+type hookDispatcher struct {
+	db      gorm.DB
+	hooks   []hook
+	client  *http.Client
+	matches chan *MatchDetail
+	done    chan struct{}
+	drain   time.Duration // Time that close waits for queued matches
+
+	// Matches that didn't fit in the queue, waiting to be dead-lettered
+	dropped     chan *MatchDetail
+	droppedDone chan struct{}
+	lost        int64 // Matches that couldn't even be dead-lettered
+
+	// Cancelled when close gives up waiting, to stop deliveries in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Hooks to fire after matches are saved, nil if there are none.
+var matchHooks *hookDispatcher
+
+// Load hooks from a JSON file.
+func loadHooks(path string) ([]hook, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hooks []hook
+	if err := json.NewDecoder(f).Decode(&hooks); err != nil {
+		return nil, err
+	}
+
+	for i, h := range hooks {
+		if (h.URL == "") == (len(h.Command) == 0) {
+			return nil, fmt.Errorf("hook %d must have exactly one of URL and Command", i)
+		}
+	}
+
+	return hooks, nil
+}
+
+func newHookDispatcher(db gorm.DB, hooks []hook) *hookDispatcher {
+	d := &hookDispatcher{
+		db:      db,
+		hooks:   hooks,
+		client:  &http.Client{Timeout: _HookTimeout},
+		matches: make(chan *MatchDetail, _HookQueueSize),
+		done:    make(chan struct{}),
+		drain:   _HookDrainTimeout,
+
+		dropped:     make(chan *MatchDetail, _HookQueueSize),
+		droppedDone: make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	go func() {
+		defer close(d.done)
+
+		for match := range d.matches {
+			d.dispatch(match)
+		}
+	}()
+
+	// Dead letters are written separately so that a stalled hook doesn't stop
+	// the queue from draining the matches dropped by fire
+	go func() {
+		defer close(d.droppedDone)
+
+		for match := range d.dropped {
+			d.drop(match, errors.New("hook queue is full"))
+		}
+	}()
+
+	return d
+}
+
+// Queue a saved match to be delivered to the hooks. Never blocks, if the queue
+// is full the match is handed off to be dead-lettered instead, and if that is
+// full too the match is only counted. Safe to call on a nil dispatcher.
+func (d *hookDispatcher) fire(match *MatchDetail) {
+	if d == nil {
+		return
+	}
+
+	select {
+	case d.matches <- match:
+		return
+	default:
+	}
+
+	select {
+	case d.dropped <- match:
+		log.Printf("Hook queue is full, dead-lettering match: %d", match.Id)
+	default:
+		atomic.AddInt64(&d.lost, 1)
+		log.Printf("Hook queue is full, dropping match: %d", match.Id)
+	}
+}
+
+// Wait for the queued matches to be delivered, for up to the drain timeout.
+// After that, deliveries in progress are stopped and the matches still queued
+// are dead-lettered. The dispatcher cannot be used afterwards. Safe to call on
+// a nil dispatcher.
+func (d *hookDispatcher) close() {
+	if d == nil {
+		return
+	}
+
+	close(d.matches)
+
+	select {
+	case <-d.done:
+	case <-time.After(d.drain):
+		log.Printf("Timed out delivering matches to hooks, dead-lettering the rest")
+		d.cancel()
+		<-d.done
+	}
+
+	d.cancel()
+
+	close(d.dropped)
+	<-d.droppedDone
+
+	if lost := atomic.LoadInt64(&d.lost); lost > 0 {
+		log.Printf("Dropped %d matches without delivering or dead-lettering them", lost)
+	}
+}
+
+// Deliver a match to all the hooks that want it.
+func (d *hookDispatcher) dispatch(match *MatchDetail) {
+	if err := d.ctx.Err(); err != nil {
+		d.drop(match, err)
+		return
+	}
+
+	for _, h := range d.hooks {
+		if !d.wants(h, match) {
+			continue
+		}
+
+		payload, err := h.payload(match)
+		if err != nil {
+			log.Printf("Unable to create hook payload for match: %d -- %s", match.Id, err.Error())
+			continue
+		}
+
+		if attempts, err := d.deliver(h, payload); err != nil {
+			log.Printf("Unable to deliver match to hook: %d -- %s", match.Id, err.Error())
+			d.deadLetter(h, match.Id, payload, attempts, err)
+		}
+	}
+}
+
+// Dead-letter a match for all the hooks that want it without trying to
+// deliver it.
+func (d *hookDispatcher) drop(match *MatchDetail, reason error) {
+	for _, h := range d.hooks {
+		if !d.wants(h, match) {
+			continue
+		}
+
+		payload, err := h.payload(match)
+		if err != nil {
+			log.Printf("Unable to create hook payload for match: %d -- %s", match.Id, err.Error())
+			continue
+		}
+
+		d.deadLetter(h, match.Id, payload, 0, reason)
+	}
+}
+
+// Check whether the hook's filters allow the match.
+func (d *hookDispatcher) wants(h hook, match *MatchDetail) bool {
+	if h.Ranked && !strings.HasPrefix(match.QueueType, "RANKED_") {
+		return false
+	}
+
+	if !h.Filter.allows(&match.BaseMatchDetail) {
+		return false
+	}
+
+	ids := make([]int64, 0, len(match.ParticipantIdentities))
+	for _, identity := range match.ParticipantIdentities {
+		ids = append(ids, identity.Player.SummonerID)
+	}
+
+	if len(h.Summoners) > 0 && !containsAny(h.Summoners, ids) {
+		return false
+	}
+
+	if h.Watched {
+		var count int
+		d.db.Model(&WatchedSummoner{}).Where("id in (?)", ids).Count(&count)
+		if count == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Create the body to send to the hook. Webhooks get a summary of the match,
+// commands get the full match.
+func (h hook) payload(match *MatchDetail) ([]byte, error) {
+	if h.URL == "" {
+		return json.Marshal(match)
+	}
+
+	summary := hookSummary{BaseMatchDetail: match.BaseMatchDetail}
+
+	for _, identity := range match.ParticipantIdentities {
+		p := hookParticipant{
+			SummonerID:   identity.Player.SummonerID,
+			SummonerName: identity.Player.SummonerName,
+		}
+
+		for _, participant := range match.Participants {
+			if participant.ParticipantID == identity.ParticipantID {
+				p.ChampionID = participant.ChampionID
+				p.TeamID = participant.TeamID
+				p.Winner = participant.Stats.Winner
+			}
+		}
+
+		summary.Participants = append(summary.Participants, p)
+	}
+
+	return json.Marshal(&summary)
+}
+
+// Try to deliver the payload to the hook, backing off between attempts.
+// Returns the number of attempts made and the last error, if all failed.
+// Gives up early if the dispatcher is cancelled.
+func (d *hookDispatcher) deliver(h hook, payload []byte) (int, error) {
+	var err error
+
+	for attempt := 0; attempt < int(*hookRetries)+1; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-d.ctx.Done():
+				return attempt, err
+			case <-time.After(*hookBackoff << uint(attempt-1)):
+			}
+		}
+
+		if h.URL != "" {
+			err = d.post(h.URL, payload)
+		} else {
+			err = runHookCommand(d.ctx, h.Command, payload)
+		}
+
+		if err == nil {
+			return attempt + 1, nil
+		}
+	}
+
+	return int(*hookRetries) + 1, err
+}
+
+func (d *hookDispatcher) post(url string, payload []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req.WithContext(d.ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code from webhook: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func runHookCommand(ctx context.Context, command []string, payload []byte) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	timer := time.AfterFunc(_HookTimeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+
+	return cmd.Wait()
+}
+
+// Save a delivery that failed all its attempts so that it isn't lost.
+func (d *hookDispatcher) deadLetter(h hook, matchID int64, payload []byte, attempts int, err error) {
+	buf, err2 := json.Marshal(&h)
+	if err2 != nil {
+		log.Printf("Unable to marshal hook: %s", err2.Error())
+		return
+	}
+
+	failed := FailedDelivery{
+		MatchId:  matchID,
+		Hook:     buf,
+		Payload:  payload,
+		Error:    err.Error(),
+		Attempts: attempts,
+		Failed:   time.Now().UnixNano(),
+	}
+
+	if err := d.db.Create(&failed).Error; err != nil {
+		log.Printf("Unable to save failed hook delivery: %d -- %s", matchID, err.Error())
+	}
+}
+
+// Check whether any of the values in b is in a.
+func containsAny(a, b []int64) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Manage failed hook deliveries. Usage:
+//
+//	hooks failed
+//	hooks replay
+func hooksCommand(db gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected one of failed or replay")
+	}
+
+	switch args[0] {
+	case "failed":
+		return listFailedDeliveries(db, os.Stdout)
+	case "replay":
+		return replayFailedDeliveries(db)
+	}
+
+	return fmt.Errorf("unknown hooks command: %s", args[0])
+}
+
+func listFailedDeliveries(db gorm.DB, w io.Writer) error {
+	var failed []FailedDelivery
+	if err := db.Order("id asc").Find(&failed).Error; err != nil && err != gorm.RecordNotFound {
+		return err
+	}
+
+	for _, f := range failed {
+		fmt.Fprintf(w, "%d\tmatch %d\t%s\t%d attempts\t%s\n", f.Id, f.MatchId,
+			time.Unix(0, f.Failed).Format(time.RFC3339), f.Attempts, f.Error)
+	}
+
+	return nil
+}
+
+// Try to deliver each of the dead letters again, removing the ones that
+// succeed.
+func replayFailedDeliveries(db gorm.DB) error {
+	var failed []FailedDelivery
+	if err := db.Order("id asc").Find(&failed).Error; err != nil && err != gorm.RecordNotFound {
+		return err
+	}
+
+	d := &hookDispatcher{db: db, client: &http.Client{Timeout: _HookTimeout}, ctx: context.Background()}
+	delivered := 0
+
+	for _, f := range failed {
+		var h hook
+		if err := json.Unmarshal(f.Hook, &h); err != nil {
+			return err
+		}
+
+		attempts, err := d.deliver(h, f.Payload)
+		if err != nil {
+			f.Attempts += attempts
+			f.Error = err.Error()
+			f.Failed = time.Now().UnixNano()
+			db.Save(&f)
+			continue
+		}
+
+		if err := db.Delete(&f).Error; err != nil {
+			return err
+		}
+		delivered++
+	}
+
+	log.Printf("Delivered %d of %d failed hook deliveries", delivered, len(failed))
+
+	return nil
+}
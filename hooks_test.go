@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testHookMatch(id int64, queue string) *MatchDetail {
+	match := &MatchDetail{
+		ParticipantIdentities: []ParticipantIdentity{
+			{ParticipantID: 1, Player: Player{SummonerID: 100, SummonerName: "Hooked"}},
+		},
+		Participants: []Participant{
+			{ParticipantID: 1, ChampionID: 103, TeamID: 100, Stats: ParticipantStats{Winner: true}},
+		},
+	}
+	match.Id = id
+	match.QueueType = queue
+
+	return match
+}
+
+func TestHookWebhook(t *testing.T) {
+	received := make(chan hookSummary, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary hookSummary
+		if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+			t.Errorf("Unable to decode webhook body: %s", err.Error())
+		}
+		received <- summary
+	}))
+	defer server.Close()
+
+	d := newHookDispatcher(db, []hook{{URL: server.URL, Ranked: true, Summoners: []int64{100}}})
+	d.fire(testHookMatch(1, "NORMAL_5x5_BLIND"))
+	d.fire(testHookMatch(2, "RANKED_SOLO_5x5"))
+	d.close()
+
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 webhook delivery, got %d", len(received))
+	}
+
+	summary := <-received
+	if summary.Id != 2 || len(summary.Participants) != 1 || summary.Participants[0].ChampionID != 103 {
+		t.Errorf("Unexpected webhook summary: %#v", summary)
+	}
+}
+
+func TestHookCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "match.json")
+
+	d := newHookDispatcher(db, []hook{{Command: []string{"sh", "-c", "cat > " + out}}})
+	d.fire(testHookMatch(3, "RANKED_SOLO_5x5"))
+	d.close()
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Hook command did not run: %s", err.Error())
+	}
+
+	var match MatchDetail
+	if err := json.Unmarshal(buf, &match); err != nil {
+		t.Fatalf("Unable to decode match from command: %s", err.Error())
+	}
+
+	if match.Id != 3 {
+		t.Errorf("Expected match 3, got %d", match.Id)
+	}
+}
+
+func TestHookDeadLetter(t *testing.T) {
+	oldRetries, oldBackoff := *hookRetries, *hookBackoff
+	*hookRetries, *hookBackoff = 1, time.Millisecond
+	defer func() {
+		*hookRetries, *hookBackoff = oldRetries, oldBackoff
+	}()
+
+	requests := 0
+	fail := true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	d := newHookDispatcher(db, []hook{{URL: server.URL}})
+	d.fire(testHookMatch(4, "RANKED_SOLO_5x5"))
+	d.close()
+
+	if requests != 2 {
+		t.Errorf("Expected 2 attempts, got %d", requests)
+	}
+
+	var failed []FailedDelivery
+	db.Where(&FailedDelivery{MatchId: 4}).Find(&failed)
+	if len(failed) != 1 || failed[0].Attempts != 2 {
+		t.Fatalf("Expected one dead letter with 2 attempts, got %#v", failed)
+	}
+
+	// Once the receiver recovers, replaying should deliver and remove it
+	fail = false
+	if err := replayFailedDeliveries(db); err != nil {
+		t.Fatalf("Unable to replay failed deliveries: %s", err.Error())
+	}
+
+	if !db.Where(&FailedDelivery{MatchId: 4}).First(&FailedDelivery{}).RecordNotFound() {
+		t.Error("Expected dead letter to be removed after replay")
+	}
+}
+
+func TestHookStalled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	d := newHookDispatcher(db, []hook{{URL: server.URL}})
+	d.drain = 50 * time.Millisecond
+
+	// A webhook that never answers mustn't hold up saving matches
+	fired := make(chan struct{})
+	go func() {
+		for id := int64(2100); id < 2100+_HookQueueSize+2; id++ {
+			d.fire(testHookMatch(id, "RANKED_SOLO_5x5"))
+		}
+		close(fired)
+	}()
+
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected fire not to block when the queue is full")
+	}
+
+	start := time.Now()
+	d.close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected close to give up after the drain timeout, took %s", elapsed)
+	}
+
+	// Every match is either dead-lettered when the queue is full or when
+	// close gives up
+	var count int
+	db.Model(&FailedDelivery{}).Where("match_id >= ? AND match_id < ?", 2100, 2100+_HookQueueSize+2).Count(&count)
+	if count != _HookQueueSize+2 {
+		t.Errorf("Expected %d dead letters, got %d", _HookQueueSize+2, count)
+	}
+}
+
+func TestHookLost(t *testing.T) {
+	// Nothing is reading either queue, as if the dead letters were stalled too
+	d := &hookDispatcher{matches: make(chan *MatchDetail), dropped: make(chan *MatchDetail)}
+
+	fired := make(chan struct{})
+	go func() {
+		d.fire(testHookMatch(2202, "RANKED_SOLO_5x5"))
+		close(fired)
+	}()
+
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected fire not to block when the dead letters are full")
+	}
+
+	if d.lost != 1 {
+		t.Errorf("Expected the match to be counted as lost, got %d", d.lost)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// Import match details from dumps in the same shape as the match API
+// responses. Each argument may be a file or a directory of files, and each
+// file may be gzipped and may contain a JSON array of matches or JSON objects
+// one after another, such as JSON lines. Matches are saved in batches of
+// -write-batch so larger batches make for faster imports. Summoners from the
+// imported matches that we don't know about are queued for crawling after
+// each batch. Files and batches that can't be imported are logged and skipped
+// so that the rest of the import still goes ahead.
+func importCommand(db gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected files or directories to import")
+	}
+
+	im := newImporter(newMatchWriter(newSQLStore(db), int(*writeBatch)))
+
+	for _, path := range args {
+		if err := im.importPath(path); err != nil {
+			return err
+		}
+	}
+
+	if err := im.finish(); err != nil {
+		return err
+	}
+
+	log.Printf("Read %d matches (%d invalid, %d duplicates, %d unsaved) with %d summoners",
+		im.read, im.invalid, im.duplicates, len(im.w.failed), len(im.summoners))
+
+	if im.badFiles > 0 || len(im.w.failed) > 0 {
+		return fmt.Errorf("unable to import %d files and %d matches", im.badFiles, len(im.w.failed))
+	}
+
+	return nil
+}
+
+// importer validates and deduplicates matches read from dumps before adding
+// them to the writer.
+type importer struct {
+	w         *matchWriter
+	seen      map[int64]bool // Matches read so far
+	summoners map[int64]bool // Summoners from the new matches
+	unqueued  []int64        // Summoners that haven't been queued yet
+
+	read, invalid, duplicates, badFiles int
+}
+
+func newImporter(w *matchWriter) *importer {
+	return &importer{
+		w:         w,
+		seen:      make(map[int64]bool),
+		summoners: make(map[int64]bool),
+	}
+}
+
+// Import a file or all the files in a directory, skipping hidden files.
+// Files that can't be read are logged and skipped.
+func (im *importer) importPath(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Unable to import file: %s -- %s", path, err.Error())
+			im.badFiles++
+			return nil
+		}
+
+		hidden := strings.HasPrefix(info.Name(), ".") && path != root
+		if info.IsDir() {
+			if hidden {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if hidden {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Unable to import file: %s -- %s", path, err.Error())
+			im.badFiles++
+			return nil
+		}
+		defer f.Close()
+
+		read := im.read
+		if err := im.importReader(f); err != nil {
+			log.Printf("Unable to import file: %s -- %s", path, err.Error())
+			im.badFiles++
+		}
+
+		log.Printf("Read %d matches from %s", im.read-read, path)
+		return nil
+	})
+}
+
+// Import the matches from r, which may be gzipped.
+func (im *importer) importReader(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		br = bufio.NewReader(gz)
+	}
+
+	// Skip to the first value to see whether it's an array or not
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !strings.ContainsRune(" \t\r\n", rune(c)) {
+			br.UnreadByte()
+			break
+		}
+	}
+
+	dec := json.NewDecoder(br)
+
+	if c, _ := br.Peek(1); c[0] == '[' {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+
+		for dec.More() {
+			if err := im.decode(dec); err != nil {
+				return err
+			}
+		}
+
+		_, err := dec.Token()
+		return err
+	}
+
+	for dec.More() {
+		if err := im.decode(dec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decode the next match and add it to the writer. Matches that don't fit
+// MatchDetail or that aren't valid are logged and skipped, only errors
+// reading the dump are returned.
+func (im *importer) decode(dec *json.Decoder) error {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	im.read++
+
+	match := &MatchDetail{}
+	err := json.Unmarshal(raw, match)
+	if err == nil {
+		err = validateMatch(match)
+	}
+
+	if err != nil {
+		log.Printf("Skipping invalid match: %d -- %s", match.Id, err.Error())
+		im.invalid++
+		return nil
+	}
+
+	if im.seen[match.Id] || im.w.store.hasMatch(match.Id) {
+		im.duplicates++
+		return nil
+	}
+
+	im.seen[match.Id] = true
+
+	for _, identity := range match.ParticipantIdentities {
+		if id := identity.Player.SummonerID; id != 0 && !im.summoners[id] {
+			im.summoners[id] = true
+			im.unqueued = append(im.unqueued, id)
+		}
+	}
+
+	// The writer already logs the matches it couldn't save and keeps them in
+	// failed, so a bad batch doesn't stop the import
+	im.w.add(match)
+
+	if len(im.w.matches) == 0 {
+		im.queueSummoners()
+	}
+
+	return nil
+}
+
+// Queue the summoners from the matches saved so far, keeping them for next
+// time if they can't be queued.
+func (im *importer) queueSummoners() error {
+	err := eachChunk(im.unqueued, func(chunk []int64) error {
+		if err := im.w.store.addPendingSummoners(chunk); err != nil {
+			return err
+		}
+
+		im.unqueued = im.unqueued[len(chunk):]
+		return nil
+	})
+	if err != nil {
+		log.Printf("Unable to queue summoners -- %s", err.Error())
+	}
+
+	return err
+}
+
+// Save the remaining matches and queue the summoners from the new matches.
+func (im *importer) finish() error {
+	im.w.flush()
+
+	return im.queueSummoners()
+}
+
+// Check that a match has an ID and an identity for each participant.
+func validateMatch(match *MatchDetail) error {
+	if match.Id == 0 {
+		return errors.New("missing match ID")
+	}
+
+	if len(match.Participants) == 0 {
+		return errors.New("no participants")
+	}
+
+	identities := make(map[int]bool, len(match.ParticipantIdentities))
+	for _, identity := range match.ParticipantIdentities {
+		identities[identity.ParticipantID] = true
+	}
+
+	for _, p := range match.Participants {
+		if !identities[p.ParticipantID] {
+			return fmt.Errorf("no identity for participant %d", p.ParticipantID)
+		}
+	}
+
+	return nil
+}
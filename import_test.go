@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Dump of a match in the shape of the match API response.
+func testDumpMatch(id, summoner int64) string {
+	return fmt.Sprintf(`{"matchId": %d, "queueType": "RANKED_SOLO_5x5",
+	"participantIdentities": [{"participantId": 1, "player": {"summonerId": %d}}],
+	"participants": [{"participantId": 1, "championId": 103}]}`, id, summoner)
+}
+
+func TestImportMatches(t *testing.T) {
+	dump := strings.Join([]string{testDumpMatch(310, 3200), testDumpMatch(311, 3201), testDumpMatch(310, 3200)}, "\n")
+
+	im := newImporter(newMatchWriter(newSQLStore(db), 2))
+	if err := im.importReader(strings.NewReader(dump)); err != nil {
+		t.Fatalf("Unable to import matches: %s", err.Error())
+	}
+	if err := im.finish(); err != nil {
+		t.Fatalf("Unable to finish import: %s", err.Error())
+	}
+
+	if im.read != 3 || im.duplicates != 1 {
+		t.Errorf("Expected 3 matches to be read with 1 duplicate, got %d and %d", im.read, im.duplicates)
+	}
+
+	if !hasMatch(db, 310) || !hasMatch(db, 311) {
+		t.Error("Expected imported matches to be saved")
+	}
+
+	for _, id := range []int64{3200, 3201} {
+		if db.First(&PendingSummoner{}, id).RecordNotFound() {
+			t.Errorf("Expected summoner %d to be queued", id)
+		}
+	}
+}
+
+func TestImportFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testDumpMatch(1401, 3202)))
+	w.Close()
+
+	files := map[string][]byte{
+		"array.json":       []byte(" [" + testDumpMatch(1400, 3202) + ",\n" + testDumpMatch(1402, 3202) + "]"),
+		"dump.json.gz":     gz.Bytes(),
+		"sub/invalid.json": []byte(`{"matchId": 1403} {"matchId": "1404"}` + testDumpMatch(1405, 3202)),
+		".hidden/x.json":   []byte(testDumpMatch(1406, 3202)),
+	}
+
+	for name, buf := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	im := newImporter(newMatchWriter(newSQLStore(db), 10))
+	if err := im.importPath(dir); err != nil {
+		t.Fatalf("Unable to import matches: %s", err.Error())
+	}
+	if err := im.finish(); err != nil {
+		t.Fatalf("Unable to finish import: %s", err.Error())
+	}
+
+	if im.read != 6 || im.invalid != 2 {
+		t.Errorf("Expected 6 matches to be read with 2 invalid, got %d and %d", im.read, im.invalid)
+	}
+
+	for _, id := range []int64{1400, 1401, 1402, 1405} {
+		if !hasMatch(db, id) {
+			t.Errorf("Expected match %d to be imported", id)
+		}
+	}
+
+	for _, id := range []int64{1403, 1404, 1406} {
+		if hasMatch(db, id) {
+			t.Errorf("Expected match %d to be skipped", id)
+		}
+	}
+}
+
+func TestImportBadBatch(t *testing.T) {
+	s := &badStore{newMemStore(), 3}
+
+	var dump []string
+	for i := int64(1); i <= 5; i++ {
+		dump = append(dump, testDumpMatch(i, 100+i))
+	}
+
+	im := newImporter(newMatchWriter(s, 2))
+	if err := im.importReader(strings.NewReader(strings.Join(dump, "\n") + `{"matchId": `)); err == nil {
+		t.Error("Expected an error reading the truncated dump")
+	}
+
+	// Summoners are queued with each batch, not just at the end
+	if !s.pending[101] || !s.pending[104] {
+		t.Errorf("Expected summoners from saved batches to be queued, got %v", s.pending)
+	}
+
+	if err := im.finish(); err != nil {
+		t.Fatalf("Unable to finish import: %s", err.Error())
+	}
+
+	for i := int64(1); i <= 5; i++ {
+		if s.hasMatch(i) == (i == 3) {
+			t.Errorf("Expected match %d to be saved: %t", i, i != 3)
+		}
+
+		if !s.pending[100+i] {
+			t.Errorf("Expected summoner %d to be queued", 100+i)
+		}
+	}
+
+	if len(im.w.failed) != 1 || im.w.failed[0] != 3 {
+		t.Errorf("Expected match 3 to fail, got %v", im.w.failed)
+	}
+}
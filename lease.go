@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// Summoners are claimed by a crawler process before they are crawled so that
+// several processes can share a database without duplicating requests. A
+// claim is a lease: it expires unless it is renewed so that the summoners
+// claimed by a crawler that crashed go back into the pool.
+
+// Get the default name for this worker, unique to the host and process.
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// Claim up to n summoners that were last crawled before due. Summoners claimed
+// by other workers are skipped unless their lease has expired. With skipLocked,
+// rows that another worker is in the middle of claiming are skipped too, which
+// needs PostgreSQL. Returns the claimed summoners, ordered by when they were
+// last crawled.
+func claimSummoners(db gorm.DB, worker string, lease time.Duration, due int64, n int, skipLocked bool) ([]Summoner, error) {
+	now := time.Now().UnixNano()
+	expires := now + lease.Nanoseconds()
+
+	lock := ""
+	if skipLocked {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+
+	// The claim is a single statement so that two workers can never claim the
+	// same summoner.
+	err := db.Exec(`UPDATE summoners SET claimed_by = ?, claim_expires = ?
+		WHERE id IN (SELECT id FROM summoners
+			WHERE last_crawled < ? AND (claimed_by IS NULL OR claimed_by = '' OR claimed_by = ? OR claim_expires < ?)
+			ORDER BY last_crawled ASC LIMIT ? `+lock+`)`,
+		worker, expires, due, worker, now, n).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var summoners []Summoner
+	err = db.Where("claimed_by = ? AND claim_expires = ?", worker, expires).Order("last_crawled asc").Find(&summoners).Error
+	if err == gorm.RecordNotFound {
+		err = nil
+	}
+
+	return summoners, err
+}
+
+// Extend the lease on all the summoners claimed by the worker.
+func renewClaims(db gorm.DB, worker string, lease time.Duration) {
+	expires := time.Now().Add(lease).UnixNano()
+
+	err := db.Exec("UPDATE summoners SET claim_expires = ? WHERE claimed_by = ?", expires, worker).Error
+	if err != nil {
+		log.Printf("Unable to renew claims for worker: %s -- %s", worker, err.Error())
+	}
+}
+
+// Release the claim on a summoner, if the worker still holds it.
+func releaseClaim(db *gorm.DB, worker string, id int64) error {
+	return db.Exec("UPDATE summoners SET claimed_by = '', claim_expires = 0 WHERE id = ? AND claimed_by = ?", id, worker).Error
+}
+
+// Release all the claims held by the worker so that other workers can pick
+// them up straight away.
+func releaseClaims(db gorm.DB, worker string) {
+	err := db.Exec("UPDATE summoners SET claimed_by = '', claim_expires = 0 WHERE claimed_by = ?", worker).Error
+	if err != nil {
+		log.Printf("Unable to release claims for worker: %s -- %s", worker, err.Error())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimSummoners(t *testing.T) {
+	// Use a due time far in the past so that only the summoners created here can
+	// be claimed.
+	const due = -1000
+
+	for i := int64(1); i <= 4; i++ {
+		summoner := Summoner{Id: 1000 + i, Name: "Claimable" + string('0'+rune(i)), LastCrawled: due - i}
+		if err := db.Create(&summoner).Error; err != nil {
+			t.Fatalf("Unable to create summoner: %s", err.Error())
+		}
+	}
+
+	a, err := claimSummoners(db, "a", time.Hour, due, 3, false)
+	if err != nil {
+		t.Fatalf("Unable to claim summoners: %s", err.Error())
+	}
+
+	b, err := claimSummoners(db, "b", time.Hour, due, 3, false)
+	if err != nil {
+		t.Fatalf("Unable to claim summoners: %s", err.Error())
+	}
+
+	if len(a) != 3 || len(b) != 1 {
+		t.Fatalf("Expected workers to claim 3 and 1 summoners, got %d and %d", len(a), len(b))
+	}
+
+	// Oldest summoners should be claimed first
+	if a[0].Id != 1004 || b[0].Id != 1001 {
+		t.Errorf("Unexpected claim order: %d, %d", a[0].Id, b[0].Id)
+	}
+
+	// Once worker a's claims expire or are released, b can claim them
+	releaseClaims(db, "a")
+
+	b, err = claimSummoners(db, "b", -time.Hour, due, 10, false)
+	if err != nil {
+		t.Fatalf("Unable to claim summoners: %s", err.Error())
+	}
+
+	if len(b) != 4 {
+		t.Fatalf("Expected worker b to claim 4 summoners, got %d", len(b))
+	}
+
+	a, err = claimSummoners(db, "a", time.Hour, due, 10, false)
+	if err != nil {
+		t.Fatalf("Unable to claim summoners: %s", err.Error())
+	}
+
+	if len(a) != 4 {
+		t.Errorf("Expected worker a to claim 4 expired summoners, got %d", len(a))
+	}
+}
@@ -1,31 +1,132 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
-
-	// Import for side-effect
-	_ "github.com/jcrussell/crawlol/external/github.com/mattn/go-sqlite3"
+	"github.com/jcrussell/crawlol/feed"
+	"github.com/jcrussell/crawlol/model"
 )
 
 var (
 	rateLimitPerTenSeconds = flag.Uint("rate-per-ten-seconds", 10, "API Rate limit per ten seconds")
 	rateLimitPerTenMinutes = flag.Uint("rate-per-ten-minutes", 500, "API Rate limit per ten minutes")
 	maxRetries             = flag.Uint("max-retries", 2, "Maximum number of times to retry a request")
-	dbPath                 = flag.String("db", "crawlol.db", "Location for the SQLite database")
+	apiToken               = flag.String("token", "", "API key for commands that make requests (defaults to $TOKEN)")
+	dbPath                 = flag.String("db", "crawlol.db", "Location for the SQLite database or URL for the PostgreSQL database (postgres://...)")
+	journalMode            = flag.String("journal-mode", "WAL", "SQLite journal mode, WAL lets other processes read the database while crawling")
+	syncMode               = flag.String("synchronous", "NORMAL", "SQLite synchronous setting, how often to wait for writes to reach the disk")
+	busyTimeout            = flag.Duration("busy-timeout", 5*time.Second, "Time to wait for SQLite locks held by other processes")
+	archiveResponses       = flag.Bool("archive", false, "Save the raw body of every API response so that it can be reparsed later")
+	compression            = flag.String("compress", model.CompressGzip, "Compression for saved match JSON: none, gzip or dict (deflate with the newest dictionary from blobs train)")
 	seedSummoners          = flag.String("seed", "", "List of summoner names (separated by ',') to use to seed the database")
+	seedFile               = flag.String("seed-file", "", "File of summoner names or IDs (one per line) to use to seed the database")
+	seedMatches            = flag.Bool("seed-from-matches", false, "Seed the database with unknown summoners from stored matches")
+	seedLadder             = flag.String("seed-ladder", "", "Seed the database with the challenger and master ladders for this queue type")
+
+	// Filters on the matches that are fetched and stored, all take lists of
+	// values separated by ','.
+	includeQueues     = flag.String("include-queues", "", "Only crawl matches with these queue types")
+	excludeQueues     = flag.String("exclude-queues", "", "Never crawl matches with these queue types")
+	includeMatchTypes = flag.String("include-match-types", "", "Only crawl matches with these match types")
+	excludeMatchTypes = flag.String("exclude-match-types", "", "Never crawl matches with these match types")
+	includeMaps       = flag.String("include-maps", "", "Only crawl matches on these map IDs")
+	excludeMaps       = flag.String("exclude-maps", "", "Never crawl matches on these map IDs")
+	includeSeasons    = flag.String("include-seasons", "", "Only crawl matches from these seasons")
+	excludeSeasons    = flag.String("exclude-seasons", "", "Never crawl matches from these seasons")
+	includeModes      = flag.String("include-modes", "", "Only crawl matches with these match modes")
+	excludeModes      = flag.String("exclude-modes", "", "Never crawl matches with these match modes")
+
+	// Watchlist polling
+	watchInterval = flag.Duration("watch-interval", 5*time.Minute, "Time between polls of the watchlist")
+	watchShare    = flag.Float64("watch-share", 0.2, "Share of the rate limit reserved for polling the watchlist")
+	watchFeed     = flag.String("watch-feed", "", "File to append new matches for watched summoners to, as JSON lines")
+
+	// Sharing the database between several crawler processes
+	workerID        = flag.String("worker", defaultWorkerID(), "Name of this crawler when claiming summoners, unique among crawlers sharing the database")
+	leaseDuration   = flag.Duration("lease", 30*time.Minute, "Time until claims on summoners expire unless renewed")
+	claimBatch      = flag.Uint("claim-batch", 100, "Number of summoners to claim at a time")
+	refreshInterval = flag.Duration("refresh-interval", 7*24*time.Hour, "Time between refreshes of summoner profiles, 0 to never refresh them")
+	pruneInterval   = flag.Duration("prune-interval", 0, "Time between applying the retention policy while crawling, 0 to only apply it with the prune command")
+	writeBatch      = flag.Uint("write-batch", 15, "Number of matches, or summoners, to save to the database in each transaction")
+
+	// Hooks fired after matches are saved
+	hooksPath   = flag.String("hooks", "", "JSON file of hooks to fire after matches are saved")
+	hookRetries = flag.Uint("hook-retries", 3, "Maximum number of times to retry a failed hook")
+	hookBackoff = flag.Duration("hook-backoff", 5*time.Second, "Time to wait before the first retry of a failed hook, doubled for each retry")
+
+	// Live feed of saved matches
+	notifyChannel = flag.String("notify", feed.DefaultChannel, "PostgreSQL channel to notify when matches are saved, empty to disable")
+
+	// Retention policy, applied by prune
+	retainTimelines = flag.Duration("retain-timelines", 0, "Time to keep match timelines before stripping them, 0 to keep them forever")
+	retainSeasons   = flag.Uint("retain-seasons", 0, "Number of the most recent seasons to keep matches from, 0 to keep them all")
+	retainQueues    = flag.String("retain-queues", "", "Queue types whose matches are kept forever, timelines and all")
+
+	// Snapshots taken by backup
+	backupKeep = flag.Uint("backup-keep", 7, "Number of backups to keep, 0 to keep them all")
+	backupGzip = flag.Bool("backup-gzip", false, "Compress SQLite backups with gzip, PostgreSQL backups are always compressed")
+
+	// Exporting matches for analysis
+	exportFormat    = flag.String("export-format", _ExportCSV, "Format for exported tables: csv, jsonl or parquet")
+	exportDir       = flag.String("export-dir", "export", "Directory to write exported tables to")
+	exportShardSize = flag.Uint("export-shard-size", 1000000, "Maximum number of rows in each exported file, 0 for no limit")
+	exportQueues    = flag.String("export-queues", "", "Only export matches with these queue types")
+	exportSeasons   = flag.String("export-seasons", "", "Only export matches from these seasons")
+	exportVersion   = flag.String("export-version", "", "Only export matches whose version starts with this prefix, such as 5.14")
+	exportSince     = flag.String("export-since", "", "Only export matches created on or after this date (YYYY-MM-DD)")
+	exportUntil     = flag.String("export-until", "", "Only export matches created before this date (YYYY-MM-DD)")
+
+	// Champion stats
+	statsInterval = flag.Duration("stats-interval", 15*time.Minute, "Time between aggregating new matches into the champion stats while crawling, 0 to only aggregate them with the stats command")
+	statsQueues   = flag.String("stats-queues", "", "Only show champion stats for these queue types, combined")
+	statsByLane   = flag.Bool("stats-by-lane", false, "Show champion stats for each lane and role")
+	statsMinPicks = flag.Uint("stats-min-picks", 0, "Leave out champions picked fewer times than this from the stats")
 )
 
-var shutdownChan chan os.Signal
+// Closed when the process is interrupted so that all goroutines see it.
+var shutdownChan chan struct{}
+
+// Closed when the crawler is done so that the background loops that only run
+// while crawling, such as the stats and the pruner, stop with it.
+var crawlDone chan struct{}
+
+// command is a subcommand that can be run instead of crawling.
+type command struct {
+	usage string                                // Arguments and description
+	run   func(db gorm.DB, args []string) error // Run the command with the remaining arguments
+}
+
+var commands = map[string]command{
+	"watch":     {"add NAME|ID... | remove NAME|ID... | list -- manage the watchlist", watchCommand},
+	"fsck":      {"[repair] -- check saved matches and summoners for problems, and fix them with repair", fsckCommand},
+	"hooks":     {"failed | replay -- list or redeliver failed hook deliveries", hooksCommand},
+	"backup":    {"DIR -- take a snapshot of the database while the crawler runs, keeping the newest -backup-keep", backupCommand},
+	"blobs":     {"stats | train [SAMPLES] | recompress -- manage compression of saved match JSON", blobsCommand},
+	"export":    {"[matches | participants | teams | events]... -- write matches to CSV, JSON lines or Parquet files", exportCommand},
+	"import":    {"FILE|DIR... -- save match details from JSON dumps, gzipped or not, and queue their summoners", importCommand},
+	"merge":     {"FILE... -- merge the matches and summoners from other SQLite databases", mergeCommand},
+	"migrate":   {"[status | up [VERSION] | down VERSION] -- show or change the schema version", migrateCommand},
+	"prune":     {"-- apply the -retain-* retention policy and reclaim the space", pruneCommand},
+	"reparse":   {"-- rebuild saved matches from the archived API responses", reparseCommand},
+	"stats":     {"champions [PATCH] -- show pick, win and ban rates for the newest patch or PATCH, such as 5.14", statsCommand},
+	"subscribe": {"[CHANNEL] -- stream saved matches from the PostgreSQL feed as JSON lines", subscribeCommand},
+}
+
+func crawl(s store, c *crawler, filter *matchFilter) {
+	// Don't hold on to any claims once we're done so that other workers don't
+	// have to wait for them to expire.
+	defer s.releaseClaims(*workerID)
 
-func crawl(db gorm.DB, c *crawler) {
 	for {
 		// Check whether we should stop crawling or not
 		select {
@@ -36,11 +137,24 @@ func crawl(db gorm.DB, c *crawler) {
 			// Keep on crawlin'
 		}
 
+		// Look up any summoners found in the last batch, or by a previous run that
+		// was interrupted, so that they can be crawled too.
+		lookupPendingSummoners(s, c)
+
+		// Fetch any matches that fsck found to be broken
+		refetchPendingMatches(s, c)
+
+		// Keep the profiles of the summoners that we know about up to date
+		refreshSummoners(s, c)
+
 		// Only recrawl summoners once every 12 hours
 		lastCrawled := time.Now().Add(-12 * time.Hour)
 
-		var summoners []Summoner
-		db.Where("last_crawled < ?", lastCrawled.UnixNano()).Order("last_crawled asc").Limit(1000).Find(&summoners)
+		summoners, err := s.nextSummoners(*workerID, *leaseDuration, lastCrawled.UnixNano(), int(*claimBatch))
+		if err != nil {
+			log.Printf("Unable to claim summoners to crawl: %s", err.Error())
+			break
+		}
 
 		if len(summoners) == 0 {
 			log.Printf("All known summoners have been crawled in the last 12 hours")
@@ -49,145 +163,404 @@ func crawl(db gorm.DB, c *crawler) {
 
 		log.Printf("Crawling recent games for %d summoners", len(summoners))
 
-		newSummoners := make(map[int64]bool, 100)
 		newMatches := 0
+		writer := newMatchWriter(s, int(*writeBatch))
+		writer.queueSummoners = true
 
 		for _, summoner := range summoners {
-			// Look up the summoner's match history, keep trying to get more until we
-			// find no new matches.
-			start := int64(0)
-
-			for {
-				foundNewMatches := false
-
-				// Query for the summoner's match history
-				matches, err := c.getMatchHistory(summoner.Id, start)
-				if err != nil {
-					log.Printf("Unable to fetch recent matches for summoner: %s (start = %d) -- %s", summoner.Name, start, err.Error())
-					break
-				}
+			n, finished := crawlSummoner(s, c, filter, writer, *workerID, summoner)
+			newMatches += n
+
+			if !finished {
+				log.Println("Shutting down crawler")
+				return
+			}
 
-				// Process the matches found
-				for _, match := range matches {
-					// Construct a MatchDetail for query. Note that we cannot set MatchID in
-					// the composite literal because MatchID is a promoted field.
-					details := &MatchDetail{}
-					details.Id = match
-
-					// Check if we've seen this match before
-					if db.Where(details).First(&MarshaledMatchDetail{}).RecordNotFound() {
-						// We haven't so we should note to try to get more matches for this
-						// summoner.
-						foundNewMatches = true
-						newMatches++
-
-						// Get the actual details for the match
-						details, err := c.getMatch(match)
-						if err != nil {
-							log.Printf("Unable to fetch match details: %d -- %s", match, err.Error())
-							continue
-						}
-
-						// Save the match. If there's an error, we can still hopefully find new
-						// summoner IDs in the participant list.
-						if err := saveMatch(db, details); err != nil {
-							log.Printf(err.Error())
-						}
-
-						// Finally, process the players to find new summoners
-						for _, identity := range details.ParticipantIdentities {
-							summonerID := identity.Player.SummonerID
-
-							// Check if we have already crawled summoner ID
-							if db.Where(&Summoner{Id: summonerID}).First(&Summoner{}).RecordNotFound() {
-								// Haven't crawled summoner, add to newSummoners
-								newSummoners[summonerID] = true
-							}
-						}
-					}
+			// Hold on to the rest of the batch while we work through it
+			s.renewClaims(*workerID, *leaseDuration)
+		}
+
+		log.Printf("Crawled %d new matches", newMatches)
+	}
+}
+
+// Crawl a summoner's match history, resuming from their checkpoint if a
+// previous crawl was interrupted. Keeps paging through the history until a
+// page has no new matches. Returns the number of new matches and whether the
+// summoner was finished or not, which is only the case if we're shutting down.
+func crawlSummoner(s store, c *crawler, filter *matchFilter, w *matchWriter, worker string, summoner Summoner) (int, bool) {
+	checkpoint := s.loadCheckpoint(summoner.Id)
+	newMatches := 0
+
+	// Only this summoner's matches should go back in their checkpoint
+	w.failed = nil
+
+	// Retry the matches that couldn't be saved last time before paging through
+	// the history again
+	if retry := checkpoint.retry(); len(retry) > 0 {
+		for i, id := range retry {
+			select {
+			case <-shutdownChan:
+				if err := w.flush(); err != nil {
+					log.Print(err.Error())
 				}
 
-				// Finished one batch of 15, move on to the next if there were new
-				// matches.
-				start += 15
+				checkpoint.setRetry(append(append([]int64{}, w.failed...), retry[i:]...))
+				s.saveCheckpoint(checkpoint)
+				return newMatches, false
+			default:
+			}
+
+			if !s.hasMatch(id) && !w.has(id) {
+				newMatches++
+				crawlMatch(s, c, filter, w, id)
+			}
+		}
+
+		if err := w.flush(); err != nil {
+			log.Print(err.Error())
+		}
+
+		if len(w.failed) > 0 {
+			retryLater(s, w, worker, summoner, checkpoint)
+			return newMatches, true
+		}
+
+		checkpoint.setRetry(nil)
+		s.saveCheckpoint(checkpoint)
+	}
 
-				if !foundNewMatches {
-					break
+	for {
+		pending := checkpoint.pending()
+
+		// Query for the summoner's match history if we haven't already got the
+		// page that we're on.
+		if pending == nil {
+			matches, err := c.getMatchHistory(summoner.Id, checkpoint.Start)
+			if err != nil {
+				log.Printf("Unable to fetch recent matches for summoner: %s (start = %d) -- %s", summoner.Name, checkpoint.Start, err.Error())
+				break
+			}
+
+			// Find the matches that we haven't seen before, skipping matches that we
+			// aren't interested in before spending any of the rate limit on them.
+			pending = []int64{}
+			for _, match := range matches {
+				if filter.allows(&match) && !s.hasMatch(match.Id) {
+					pending = append(pending, match.Id)
 				}
 			}
 
-			// Finished with summoner, for now. Update the last crawled field and save
-			// to the database.
-			summoner.LastCrawled = time.Now().UnixNano()
-			if err := db.Save(&summoner).Error; err != nil {
-				log.Printf("Unable to update last crawled for summoner: %d -- %s", summoner.Id, err.Error)
+			// Keep going until we find a page without any new matches
+			if len(pending) == 0 {
+				break
 			}
+
+			checkpoint.setPending(pending)
+			s.saveCheckpoint(checkpoint)
 		}
 
-		log.Printf("Crawled %d new matches and found %d new summoners", newMatches, len(newSummoners))
-		lookupSummoners(db, c, newSummoners)
+		for len(pending) > 0 {
+			select {
+			case <-shutdownChan:
+				// Save the matches that we've already fetched before stopping
+				flushMatches(s, w, checkpoint, pending)
+				return newMatches, false
+			default:
+			}
+
+			// The match may have been saved just before we were interrupted last
+			// time, in which case there's no need to fetch it again.
+			if !s.hasMatch(pending[0]) && !w.has(pending[0]) {
+				newMatches++
+				crawlMatch(s, c, filter, w, pending[0])
+			}
+
+			pending = pending[1:]
+
+			// Only move the checkpoint past matches once they have been saved. The
+			// checkpoint moves on to the next page once the batch is flushed below.
+			if len(w.matches) == 0 && len(pending) > 0 {
+				checkpoint.setPending(append(append([]int64{}, w.failed...), pending...))
+				s.saveCheckpoint(checkpoint)
+			}
+		}
+
+		if !flushMatches(s, w, checkpoint, pending) {
+			retryLater(s, w, worker, summoner, checkpoint)
+			return newMatches, true
+		}
+	}
+
+	// Finished with summoner, for now
+	if err := s.markCrawled(worker, summoner.Id); err != nil {
+		log.Printf("Unable to update last crawled for summoner: %d -- %s", summoner.Id, err.Error())
 	}
+
+	return newMatches, true
 }
 
-// Seed the database with summoners looked up by name.
-func seedDatabase(db gorm.DB, c *crawler) {
-	names := strings.Split(*seedSummoners, ",")
+// Keep the matches that couldn't be saved in the checkpoint. Rather than
+// fetching them again straight away, they are retried when the summoner is
+// next due to be crawled.
+func retryLater(s store, w *matchWriter, worker string, summoner Summoner, checkpoint *CrawlCheckpoint) {
+	log.Printf("Leaving %d unsaved matches to retry later for summoner: %s", len(w.failed), summoner.Name)
 
-	// Find the summoner IDs for the seed summoners
-	if summoners, err := c.getSummoners(names); err != nil {
-		log.Fatalf("Unable to fetch seed summoners: %s", err.Error())
-	} else {
-		saveSummoners(db, summoners)
+	if err := s.markCrawled(worker, summoner.Id); err != nil {
+		log.Printf("Unable to update last crawled for summoner: %d -- %s", summoner.Id, err.Error())
 	}
+
+	checkpoint.setRetry(w.failed)
+	s.saveCheckpoint(checkpoint)
 }
 
-func lookupSummoners(db gorm.DB, c *crawler, summoners map[int64]bool) {
-	ids := make([]int64, 0, len(summoners))
-	for k := range summoners {
-		ids = append(ids, k)
+// Save the buffered matches and then move the checkpoint past the ones that
+// were saved. Returns false if any of the summoner's matches couldn't be saved.
+func flushMatches(s store, w *matchWriter, checkpoint *CrawlCheckpoint, pending []int64) bool {
+	if err := w.flush(); err != nil {
+		log.Print(err.Error())
 	}
 
-	for i := 0; i*_MaxSummonersPerQuery < len(ids); i++ {
-		// Create slice of length _MaxSummonersPerQuery or less if there aren't
-		// enough elements remaining.
-		ub := (i + 1) * _MaxSummonersPerQuery
-		if ub >= len(ids) {
-			ub = len(ids) - 1
-		}
-		slice := ids[i*_MaxSummonersPerQuery : ub]
+	checkpoint.setPending(append(append([]int64{}, w.failed...), pending...))
+	s.saveCheckpoint(checkpoint)
+
+	return len(w.failed) == 0
+}
+
+// Fetch and save a match. The summoners in the match are queued once it is
+// saved, or straight away if it is discarded.
+func crawlMatch(s store, c *crawler, filter *matchFilter, w *matchWriter, id int64) {
+	details, err := c.getMatch(id)
+	if err != nil {
+		log.Printf("Unable to fetch match details: %d -- %s", id, err.Error())
+		return
+	}
 
-		if res, err := c.getSummonersByID(slice); err != nil {
-			log.Printf("Unable to fetch summoners: %s", err.Error())
-		} else {
-			saveSummoners(db, res)
+	// Save the match if it still passes the filter now that we have the full
+	// details. The writer queues the summoners in the match once it is saved.
+	if filter.allows(&details.BaseMatchDetail) {
+		if err := w.add(details); err != nil {
+			log.Print(err.Error())
 		}
+
+		return
+	}
+
+	log.Printf("Discarding match that does not pass filters: %d", id)
+
+	// We can still hopefully find new summoner IDs in the participant list of
+	// the discarded match
+	ids := make([]int64, 0, len(details.ParticipantIdentities))
+	for _, identity := range details.ParticipantIdentities {
+		ids = append(ids, identity.Player.SummonerID)
+	}
+
+	if err := s.addPendingSummoners(ids); err != nil {
+		log.Printf("Unable to save pending summoners for match: %d -- %s", id, err.Error())
 	}
 }
 
+// Look up the summoners by ID and save them.
+func lookupSummoners(s store, c *crawler, summoners map[int64]bool) {
+	res, err := c.getAllSummonersByID(keys(summoners))
+	if err != nil {
+		log.Printf("Unable to fetch summoners: %s", err.Error())
+	}
+
+	if err := s.upsertSummoners(res); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// Look up the summoners whose profiles are due a refresh.
+func refreshSummoners(s store, c *crawler) {
+	if *refreshInterval <= 0 {
+		return
+	}
+
+	ids, err := s.refreshDue(time.Now().Add(-*refreshInterval), int(*claimBatch))
+	if err != nil {
+		log.Printf("Unable to find summoners to refresh: %s", err.Error())
+		return
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("Refreshing %d summoners", len(ids))
+
+	summoners := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		summoners[id] = true
+	}
+
+	lookupSummoners(s, c, summoners)
+}
+
+// Create a crawler for commands that make requests, using the token from the
+// command line or the environment.
+func newAPICrawler() (*crawler, error) {
+	token := *apiToken
+	if token == "" {
+		token = os.Getenv("TOKEN")
+	}
+
+	if token == "" {
+		return nil, errors.New("API token must be set with -token or $TOKEN")
+	}
+
+	return newCrawler(token, int(*rateLimitPerTenSeconds),
+		int(*rateLimitPerTenMinutes), int(*maxRetries)), nil
+}
+
+func usage() {
+	fmt.Printf("USAGE: %s [OPTIONS] TOKEN\n", os.Args[0])
+	fmt.Printf("       %s [OPTIONS] COMMAND [ARGS...]\n\n", os.Args[0])
+	fmt.Printf("COMMANDS:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("  %s %s\n", name, commands[name].usage)
+	}
+
+	os.Exit(1)
+}
+
 func main() {
 	flag.Parse()
 
-	if flag.NArg() != 1 {
-		fmt.Printf("USAGE: %s [OPTIONS] TOKEN\n", os.Args[0])
-		os.Exit(1)
+	if flag.NArg() < 1 {
+		usage()
 	}
 
-	c := newCrawler(flag.Arg(0), int(*rateLimitPerTenSeconds),
-		int(*rateLimitPerTenMinutes), int(*maxRetries))
+	// The migrate command manages the schema itself
+	open := openDB
+	if flag.Arg(0) == "migrate" {
+		open = connectDB
+	}
 
-	db, err := openDB(*dbPath)
+	db, err := open(*dbPath)
 	if err != nil {
 		log.Fatalf("Unable to open database: %s", err.Error())
 	}
 
-	if *seedSummoners != "" {
-		seedDatabase(db, c)
+	if err := blobs.SetMethod(*compression); err != nil {
+		log.Fatalf("Unable to set compression: %s", err.Error())
+	}
+
+	if *archiveResponses {
+		responses = &responseArchive{db}
 	}
 
-	shutdownChan = make(chan os.Signal, 1)
-	signal.Notify(shutdownChan, os.Interrupt, os.Kill)
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		if err := cmd.run(db, flag.Args()[1:]); err != nil {
+			log.Fatalf("Unable to %s: %s", flag.Arg(0), err.Error())
+		}
+		return
+	}
 
-	crawl(db, c)
+	if flag.NArg() != 1 {
+		usage()
+	}
+
+	token := flag.Arg(0)
+
+	shutdownChan = make(chan struct{})
+	crawlDone = make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, os.Kill)
+		<-signals
+		close(shutdownChan)
+	}()
+
+	filter := &matchFilter{
+		QueueType: newFieldFilter(*includeQueues, *excludeQueues),
+		MatchType: newFieldFilter(*includeMatchTypes, *excludeMatchTypes),
+		MapId:     newFieldFilter(*includeMaps, *excludeMaps),
+		Season:    newFieldFilter(*includeSeasons, *excludeSeasons),
+		MatchMode: newFieldFilter(*includeModes, *excludeModes),
+	}
+
+	if *hooksPath != "" {
+		hooks, err := loadHooks(*hooksPath)
+		if err != nil {
+			log.Fatalf("Unable to load hooks: %s", err.Error())
+		}
+
+		matchHooks = newHookDispatcher(db, hooks)
+	}
+
+	perTenSeconds := int(*rateLimitPerTenSeconds)
+	perTenMinutes := int(*rateLimitPerTenMinutes)
+
+	// Poll the watchlist in the background with its own reserved share of the
+	// rate limit. The watcher runs even if the watchlist is empty so that
+	// summoners added with watch add are picked up without a restart.
+	var watching sync.WaitGroup
+	var watchPerTenSeconds, watchPerTenMinutes int
+	perTenSeconds, watchPerTenSeconds = reserveRate(perTenSeconds, *watchShare)
+	perTenMinutes, watchPerTenMinutes = reserveRate(perTenMinutes, *watchShare)
+
+	var feedOut io.Writer
+	if *watchFeed != "" {
+		f, err := os.OpenFile(*watchFeed, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatalf("Unable to open watch feed: %s", err.Error())
+		}
+		defer f.Close()
+
+		feedOut = f
+	}
+
+	wc := newCrawler(token, watchPerTenSeconds, watchPerTenMinutes, int(*maxRetries))
+
+	watching.Add(1)
+	go func() {
+		defer watching.Done()
+		watch(newSQLStore(db), db, wc, filter, feedOut)
+	}()
+
+	// Apply the retention policy in the background while crawling
+	var pruning sync.WaitGroup
+	if *pruneInterval > 0 {
+		pruning.Add(1)
+		go func() {
+			defer pruning.Done()
+			pruneEvery(db, flagRetentionPolicy(), *pruneInterval)
+		}()
+	}
+
+	// Aggregate new matches into the champion stats as they arrive
+	var aggregating sync.WaitGroup
+	if *statsInterval > 0 {
+		aggregating.Add(1)
+		go func() {
+			defer aggregating.Done()
+			updateStatsEvery(db, *statsInterval)
+		}()
+	}
+
+	c := newCrawler(token, perTenSeconds, perTenMinutes, int(*maxRetries))
+
+	seedDatabase(newSQLStore(db), c)
+
+	crawl(newSQLStore(db), c, filter)
 
 	log.Printf("Done crawling for now")
+
+	close(crawlDone)
+
+	watching.Wait()
+	pruning.Wait()
+	aggregating.Wait()
+
+	// Make sure that all the saved matches make it to the hooks
+	matchHooks.close()
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeAPI serves canned API responses, keyed by path and beginIndex.
+type fakeAPI map[string]string
+
+func (f fakeAPI) RoundTrip(r *http.Request) (*http.Response, error) {
+	key := r.URL.Path
+	if begin := r.URL.Query().Get("beginIndex"); begin != "" {
+		key += "?beginIndex=" + begin
+	}
+
+	res := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Request: r}
+	if body, ok := f[key]; ok {
+		res.Body = ioutil.NopCloser(strings.NewReader(body))
+	} else {
+		res.StatusCode, res.Status = http.StatusNotFound, "404 Not Found"
+		res.Body = ioutil.NopCloser(strings.NewReader(""))
+	}
+
+	return res, nil
+}
+
+func TestCrawl(t *testing.T) {
+	api := fakeAPI{
+		"/api/lol/na/v2.2/matchhistory/1?beginIndex=0":  `{"matches": [{"matchId": 10}, {"matchId": 11}]}`,
+		"/api/lol/na/v2.2/matchhistory/1?beginIndex=15": `{"matches": []}`,
+		"/api/lol/na/v2.2/match/10": `{"matchId": 10, "participantIdentities": [
+			{"participantId": 1, "player": {"summonerId": 1}}, {"participantId": 2, "player": {"summonerId": 2}}]}`,
+		"/api/lol/na/v2.2/match/11":   `{"matchId": 11}`,
+		"/api/lol/na/v1.4/summoner/2": `{"two": {"id": 2, "name": "Two"}}`,
+	}
+
+	fake := newCrawler("key", 100, 100, 1)
+	fake.Client = &http.Client{Transport: api}
+
+	s := newMemStore()
+	s.upsertSummoners(map[string]Summoner{"one": {Id: 1, Name: "One"}})
+
+	crawl(s, fake, nil)
+
+	if !s.hasMatch(10) || !s.hasMatch(11) {
+		t.Error("Expected both matches to be saved")
+	}
+
+	// The summoner found in the match should have been looked up and crawled
+	for _, id := range []int64{1, 2} {
+		summoner, ok := s.summoners[id]
+		if !ok || summoner.LastCrawled == 0 || summoner.ClaimedBy != "" {
+			t.Errorf("Expected summoner %d to be crawled and released, got %#v", id, summoner)
+		}
+	}
+
+	if len(s.checkpoints) != 0 || len(s.pending) != 0 {
+		t.Errorf("Expected no checkpoints or pending summoners, got %v and %v", s.checkpoints, s.pending)
+	}
+}
+
+func TestCrawlUnsavedMatches(t *testing.T) {
+	api := fakeAPI{
+		"/api/lol/na/v2.2/matchhistory/1?beginIndex=0":  `{"matches": [{"matchId": 10}, {"matchId": 11}]}`,
+		"/api/lol/na/v2.2/matchhistory/1?beginIndex=15": `{"matches": []}`,
+		"/api/lol/na/v2.2/match/10":                     `{"matchId": 10}`,
+		"/api/lol/na/v2.2/match/11":                     `{"matchId": 11}`,
+	}
+
+	fake := newCrawler("key", 100, 100, 1)
+	fake.Client = &http.Client{Transport: api}
+
+	s := &badStore{newMemStore(), 11}
+	s.upsertSummoners(map[string]Summoner{"one": {Id: 1, Name: "One"}})
+
+	crawl(s, fake, nil)
+
+	if !s.hasMatch(10) || s.hasMatch(11) {
+		t.Error("Expected only match 10 to be saved")
+	}
+
+	// The match that couldn't be saved is retried on the next crawl, which
+	// starts from the newest page of the history again
+	checkpoint := s.checkpoints[1]
+	if retry := checkpoint.retry(); len(retry) != 1 || retry[0] != 11 || checkpoint.Start != 0 || checkpoint.Pending != nil {
+		t.Errorf("Expected match 11 to be left in the checkpoint, got %v at %d", retry, checkpoint.Start)
+	}
+
+	if s.summoners[1].LastCrawled == 0 {
+		t.Error("Expected summoner to be marked as crawled")
+	}
+
+	// A new match is played before the retry
+	api["/api/lol/na/v2.2/matchhistory/1?beginIndex=0"] = `{"matches": [{"matchId": 12}, {"matchId": 10}, {"matchId": 11}]}`
+	api["/api/lol/na/v2.2/match/12"] = `{"matchId": 12}`
+	s.bad = 0
+
+	if _, finished := crawlSummoner(s, fake, nil, newMatchWriter(s, 1), "", s.summoners[1]); !finished {
+		t.Fatal("Expected summoner to be finished")
+	}
+
+	if !s.hasMatch(11) || !s.hasMatch(12) {
+		t.Error("Expected the retried match and the new match to be saved")
+	}
+
+	if _, ok := s.checkpoints[1]; ok {
+		t.Errorf("Expected checkpoint to be removed, got %#v", s.checkpoints[1])
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memStore keeps everything in memory. It's meant for testing the crawler
+// and for short crawls where nothing needs to be kept.
+type memStore struct {
+	sync.Mutex
+
+	matches     map[int64]*MatchDetail
+	summoners   map[int64]Summoner
+	checkpoints map[int64]CrawlCheckpoint
+	pending     map[int64]bool
+	refetch     map[int64]int // Matches queued to be fetched again, with their failed attempts
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		matches:     make(map[int64]*MatchDetail),
+		summoners:   make(map[int64]Summoner),
+		checkpoints: make(map[int64]CrawlCheckpoint),
+		pending:     make(map[int64]bool),
+		refetch:     make(map[int64]int),
+	}
+}
+
+func (s *memStore) hasMatch(id int64) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.matches[id] != nil
+}
+
+func (s *memStore) saveMatches(matches []*MatchDetail) ([]*MatchDetail, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var saved []*MatchDetail
+	for _, match := range matches {
+		if s.matches[match.Id] == nil {
+			s.matches[match.Id] = match
+			saved = append(saved, match)
+		}
+	}
+
+	return saved, nil
+}
+
+func (s *memStore) nextSummoners(worker string, lease time.Duration, due int64, n int) ([]Summoner, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().UnixNano()
+
+	var res []Summoner
+	for _, summoner := range s.summoners {
+		claimable := summoner.ClaimedBy == "" || summoner.ClaimedBy == worker || summoner.ClaimExpires < now
+		if summoner.LastCrawled < due && claimable {
+			res = append(res, summoner)
+		}
+	}
+
+	sort.Sort(byLastCrawled(res))
+	if len(res) > n {
+		res = res[:n]
+	}
+
+	for i := range res {
+		res[i].ClaimedBy = worker
+		res[i].ClaimExpires = now + lease.Nanoseconds()
+		s.summoners[res[i].Id] = res[i]
+	}
+
+	return res, nil
+}
+
+func (s *memStore) renewClaims(worker string, lease time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	expires := time.Now().Add(lease).UnixNano()
+	for id, summoner := range s.summoners {
+		if summoner.ClaimedBy == worker {
+			summoner.ClaimExpires = expires
+			s.summoners[id] = summoner
+		}
+	}
+}
+
+func (s *memStore) releaseClaims(worker string) {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, summoner := range s.summoners {
+		if summoner.ClaimedBy == worker {
+			summoner.ClaimedBy, summoner.ClaimExpires = "", 0
+			s.summoners[id] = summoner
+		}
+	}
+}
+
+func (s *memStore) markCrawled(worker string, id int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.checkpoints, id)
+
+	summoner := s.summoners[id]
+	summoner.LastCrawled = time.Now().UnixNano()
+	if summoner.ClaimedBy == worker {
+		summoner.ClaimedBy, summoner.ClaimExpires = "", 0
+	}
+	s.summoners[id] = summoner
+
+	return nil
+}
+
+func (s *memStore) upsertSummoners(summoners map[string]Summoner) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().UnixNano()
+
+	for _, summoner := range summoners {
+		if old, ok := s.summoners[summoner.Id]; ok {
+			summoner.LastCrawled = old.LastCrawled
+			summoner.ClaimedBy, summoner.ClaimExpires = old.ClaimedBy, old.ClaimExpires
+		} else {
+			summoner.LastCrawled = 0
+			summoner.ClaimedBy, summoner.ClaimExpires = "", 0
+		}
+
+		summoner.Refreshed = now
+		s.summoners[summoner.Id] = summoner
+	}
+
+	return nil
+}
+
+func (s *memStore) refreshDue(before time.Time, n int) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().UnixNano()
+	revised := before.UnixNano() / int64(time.Millisecond)
+
+	var res []int64
+	for id, summoner := range s.summoners {
+		if len(res) < n && summoner.RevisionDate < revised && summoner.Refreshed < before.UnixNano() {
+			summoner.Refreshed = now
+			s.summoners[id] = summoner
+			res = append(res, id)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *memStore) loadCheckpoint(id int64) *CrawlCheckpoint {
+	s.Lock()
+	defer s.Unlock()
+
+	checkpoint := s.checkpoints[id]
+	checkpoint.Id = id
+
+	return &checkpoint
+}
+
+func (s *memStore) saveCheckpoint(c *CrawlCheckpoint) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.checkpoints[c.Id] = *c
+}
+
+func (s *memStore) addPendingSummoners(ids []int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, id := range ids {
+		if _, ok := s.summoners[id]; !ok {
+			s.pending[id] = true
+		}
+	}
+
+	return nil
+}
+
+func (s *memStore) pendingSummoners() ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return keys(s.pending), nil
+}
+
+func (s *memStore) removePendingSummoners(ids []int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, id := range ids {
+		delete(s.pending, id)
+	}
+
+	return nil
+}
+
+func (s *memStore) unknownSummoners() (map[int64]bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	unknown := make(map[int64]bool)
+	for _, match := range s.matches {
+		for _, identity := range match.ParticipantIdentities {
+			id := identity.Player.SummonerID
+			if _, ok := s.summoners[id]; !ok {
+				unknown[id] = true
+			}
+		}
+	}
+
+	return unknown, nil
+}
+
+func (s *memStore) addPendingMatches(ids []int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, id := range ids {
+		if _, ok := s.refetch[id]; !ok {
+			s.refetch[id] = 0
+		}
+	}
+
+	return nil
+}
+
+func (s *memStore) pendingMatches() ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var ids []int64
+	for id, attempts := range s.refetch {
+		if attempts < _MaxRefetchAttempts {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (s *memStore) failPendingMatches(ids []int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, id := range ids {
+		if _, ok := s.refetch[id]; ok {
+			s.refetch[id]++
+		}
+	}
+
+	return nil
+}
+
+func (s *memStore) removePendingMatches(ids []int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, id := range ids {
+		delete(s.refetch, id)
+	}
+
+	return nil
+}
+
+type byLastCrawled []Summoner
+
+func (s byLastCrawled) Len() int           { return len(s) }
+func (s byLastCrawled) Less(i, j int) bool { return s[i].LastCrawled < s[j].LastCrawled }
+func (s byLastCrawled) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// mergeReport counts what happened while merging a database.
+type mergeReport struct {
+	matches, duplicates int      // Matches merged and matches that were already saved
+	added, updated      int      // Summoners added and summoners updated
+	pending             int      // Pending summoners read
+	conflicts           []string // Summoners that differed between the databases
+}
+
+func (r *mergeReport) add(other *mergeReport) {
+	r.matches += other.matches
+	r.duplicates += other.duplicates
+	r.added += other.added
+	r.updated += other.updated
+	r.pending += other.pending
+	r.conflicts = append(r.conflicts, other.conflicts...)
+}
+
+func (r *mergeReport) summary() string {
+	return fmt.Sprintf("%d matches merged, %d duplicates, %d summoners added, %d updated, %d pending, %d conflicts",
+		r.matches, r.duplicates, r.added, r.updated, r.pending, len(r.conflicts))
+}
+
+func (r *mergeReport) print(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s: %s\n", name, r.summary())
+
+	for _, conflict := range r.conflicts {
+		fmt.Fprintf(w, "\t%s\n", conflict)
+	}
+}
+
+// Merge the matches, summoners and pending summoners from another SQLite
+// database into db. Matches are deduplicated by ID. Summoners that are in
+// both keep the profile with the freshest revision date and the latest last
+// crawled time.
+func mergeDatabase(db gorm.DB, path string) (*mergeReport, error) {
+	// Opening a SQLite database that doesn't exist creates it
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	src, err := openMergeSource(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// The source's blobs may use dictionaries with the same IDs as ours
+	codec := model.NewCodec()
+	if err := codec.Load(src); err != nil {
+		return nil, err
+	}
+
+	report := &mergeReport{}
+
+	if err := mergeMatches(db, src, codec, report); err != nil {
+		return report, err
+	}
+
+	if err := mergeSummoners(db, src, report); err != nil {
+		return report, err
+	}
+
+	// Databases from before pending summoners were saved don't have the table
+	var pending []int64
+	if src.HasTable(&PendingSummoner{}) {
+		if err := src.Model(&PendingSummoner{}).Pluck("id", &pending).Error; err != nil {
+			return report, err
+		}
+	}
+
+	report.pending = len(pending)
+
+	return report, addPendingSummoners(db, pending)
+}
+
+// Open the database to merge read-only with the plain SQLite driver, so that
+// neither tuneSQLite nor anything else changes someone else's file.
+func openMergeSource(path string) (gorm.DB, error) {
+	dsn := (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}).String()
+
+	src, err := gorm.Open(_SQLite, _SQLite, dsn)
+	if err != nil {
+		return gorm.DB{}, err
+	}
+
+	// Opening is lazy, make sure that we can actually read it
+	if err := src.DB().Ping(); err != nil {
+		return gorm.DB{}, err
+	}
+
+	src.InstantSet(_DriverKey, _SQLite)
+	src.SetLogger(fakeLogger{})
+
+	return src, nil
+}
+
+// Copy the matches from src that aren't in db, recompressing them with our
+// codec.
+func mergeMatches(db, src gorm.DB, codec *model.Codec, report *mergeReport) error {
+	var ids []int64
+	if err := src.Model(&MarshaledMatchDetail{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	w := newMatchWriter(newSQLStore(db), int(*writeBatch))
+
+	for _, id := range ids {
+		if w.has(id) || hasMatch(db, id) {
+			report.duplicates++
+			continue
+		}
+
+		row := &MarshaledMatchDetail{}
+		if err := src.First(row, id).Error; err != nil {
+			return err
+		}
+
+		match, err := row.Unmarshal(codec)
+		if err != nil {
+			log.Printf("Unable to unmarshal match details: %d -- %s", id, err.Error())
+			continue
+		}
+
+		if err := w.add(match); err != nil {
+			return err
+		}
+
+		report.matches++
+	}
+
+	return w.flush()
+}
+
+// Reconcile the summoners from src with the ones in db, in transactions of up
+// to -write-batch summoners.
+func mergeSummoners(db, src gorm.DB, report *mergeReport) error {
+	size := int(*writeBatch)
+	if size < 1 {
+		size = 1
+	}
+
+	var last int64
+	for {
+		var batch []Summoner
+		if err := src.Where("id > ?", last).Order("id").Limit(size).Find(&batch).Error; err != nil && err != gorm.RecordNotFound {
+			return err
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		last = batch[len(batch)-1].Id
+
+		if res, err := mergeSummonerBatch(db, batch); err == nil {
+			report.add(res)
+			continue
+		}
+
+		// Fall back to merging them one at a time so that one bad summoner
+		// doesn't stop the rest from being merged.
+		for _, summoner := range batch {
+			res, err := mergeSummonerBatch(db, []Summoner{summoner})
+			if err != nil {
+				res = &mergeReport{conflicts: []string{fmt.Sprintf("summoner %d (%s): unable to merge -- %s", summoner.Id, summoner.Name, err.Error())}}
+			}
+
+			report.add(res)
+		}
+	}
+}
+
+// Merge the batch of summoners in a single transaction.
+func mergeSummonerBatch(db gorm.DB, summoners []Summoner) (*mergeReport, error) {
+	report := &mergeReport{}
+
+	tx := db.Begin()
+
+	for _, summoner := range summoners {
+		var err error
+
+		existing := Summoner{}
+		if tx.First(&existing, summoner.Id).RecordNotFound() {
+			// Claims only mean something to the crawlers of the other database
+			summoner.ClaimedBy, summoner.ClaimExpires = "", 0
+			err = tx.Create(&summoner).Error
+			report.added++
+		} else if updates := reconcileSummoner(existing, summoner, report); len(updates) > 0 {
+			err = tx.Model(&existing).UpdateColumns(updates).Error
+			report.updated++
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return report, tx.Commit().Error
+}
+
+// Find the columns of existing to update from other, noting any conflict
+// between their profiles in the report.
+func reconcileSummoner(existing, other Summoner, report *mergeReport) map[string]interface{} {
+	updates := map[string]interface{}{}
+
+	if other.LastCrawled > existing.LastCrawled {
+		updates["last_crawled"] = other.LastCrawled
+	}
+
+	if other.Name == existing.Name && other.ProfileIconId == existing.ProfileIconId &&
+		other.SummonerLevel == existing.SummonerLevel && other.RevisionDate == existing.RevisionDate {
+		return updates
+	}
+
+	kept := existing
+	if other.RevisionDate > existing.RevisionDate {
+		kept = other
+		updates["name"] = other.Name
+		updates["profile_icon_id"] = other.ProfileIconId
+		updates["revision_date"] = other.RevisionDate
+		updates["summoner_level"] = other.SummonerLevel
+	}
+
+	report.conflicts = append(report.conflicts, fmt.Sprintf("summoner %d: %s (level %d, revised %d) and %s (level %d, revised %d), kept %s",
+		existing.Id, existing.Name, existing.SummonerLevel, existing.RevisionDate,
+		other.Name, other.SummonerLevel, other.RevisionDate, kept.Name))
+
+	return updates
+}
+
+// Merge other SQLite databases into the database. Usage:
+//
+//	merge FILE...
+func mergeCommand(db gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected databases to merge")
+	}
+
+	total := &mergeReport{}
+
+	for _, path := range args {
+		report, err := mergeDatabase(db, path)
+		if report != nil {
+			report.print(os.Stdout, path)
+			total.add(report)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+
+	if len(args) > 1 {
+		fmt.Printf("total: %s\n", total.summary())
+	}
+
+	return nil
+}
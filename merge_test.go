@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+func TestMergeDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "other.db")
+
+	src, err := openDB(path)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+
+	for _, id := range []int64{1500, 1501} {
+		if err := saveMatch(src, testHookMatch(id, "RANKED_SOLO_5x5")); err != nil {
+			t.Fatalf("Unable to save match: %s", err.Error())
+		}
+	}
+
+	src.Create(&Summoner{Id: 3300, Name: "Merged0", LastCrawled: 7, ClaimedBy: "other"})
+	src.Create(&Summoner{Id: 3301, Name: "Renamed1", RevisionDate: 20, LastCrawled: 40})
+	src.Create(&Summoner{Id: 3302, Name: "Old2", RevisionDate: 20, LastCrawled: 60})
+	src.Create(&PendingSummoner{Id: 3303})
+	src.Close()
+
+	saveMatch(db, testHookMatch(1501, "RANKED_SOLO_5x5"))
+	db.Create(&Summoner{Id: 3301, Name: "Merged1", RevisionDate: 10, LastCrawled: 50})
+	db.Create(&Summoner{Id: 3302, Name: "Merged2", RevisionDate: 30, LastCrawled: 5})
+
+	report, err := mergeDatabase(db, path)
+	if err != nil {
+		t.Fatalf("Unable to merge database: %s", err.Error())
+	}
+
+	if report.matches != 1 || report.duplicates != 1 {
+		t.Errorf("Expected 1 match merged and 1 duplicate, got %s", report.summary())
+	}
+	if report.added != 1 || report.updated != 2 || len(report.conflicts) != 2 {
+		t.Errorf("Expected 1 summoner added, 2 updated with conflicts, got %s", report.summary())
+	}
+
+	if !hasMatch(db, 1500) {
+		t.Error("Expected match to be merged")
+	}
+
+	expected := []Summoner{
+		{Id: 3300, Name: "Merged0", LastCrawled: 7},
+		{Id: 3301, Name: "Renamed1", LastCrawled: 50},
+		{Id: 3302, Name: "Merged2", LastCrawled: 60},
+	}
+
+	for _, e := range expected {
+		var s Summoner
+		db.First(&s, e.Id)
+		if s.Name != e.Name || s.LastCrawled != e.LastCrawled || s.ClaimedBy != "" {
+			t.Errorf("Expected summoner %d to be %s crawled at %d, got %s crawled at %d", e.Id, e.Name, e.LastCrawled, s.Name, s.LastCrawled)
+		}
+	}
+
+	if db.First(&PendingSummoner{}, 3303).RecordNotFound() {
+		t.Error("Expected pending summoner to be merged")
+	}
+}
+
+func TestMergeOldDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "old.db")
+
+	// A database from before pending summoners and blob dictionaries, created
+	// without tuneSQLite so that it's still in the default journal mode
+	src, err := gorm.Open(_SQLite, _SQLite, path)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+
+	src.AutoMigrate(&summonerV1{}, &marshaledMatchDetailV1{})
+
+	row, err := testHookMatch(1510, "RANKED_SOLO_5x5").Marshal(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Create(row).Error; err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+	src.Create(&summonerV1{Id: 3310, Name: "Old"})
+	src.Close()
+
+	report, err := mergeDatabase(db, path)
+	if err != nil {
+		t.Fatalf("Unable to merge database: %s", err.Error())
+	}
+
+	if report.matches != 1 || report.added != 1 || report.pending != 0 {
+		t.Errorf("Expected 1 match and 1 summoner merged, got %s", report.summary())
+	}
+
+	if !hasMatch(db, 1510) || db.First(&Summoner{}, 3310).RecordNotFound() {
+		t.Error("Expected match and summoner to be merged")
+	}
+
+	src, err = gorm.Open(_SQLite, _SQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	var mode string
+	if err := src.DB().QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "delete" {
+		t.Errorf("Expected the merged database to keep its journal mode, got %s (%v)", mode, err)
+	}
+}
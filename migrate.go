@@ -0,0 +1,522 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// SchemaVersion records a migration that has been applied to the database.
+type SchemaVersion struct {
+	Id          int    // Version number of the migration
+	Description string // What the migration does
+	Applied     int64  // When the migration was applied, specified as epoch nanoseconds
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// migration is a numbered step that changes the schema, or the data, from
+// one version to the next. The down step reverts the up step.
+type migration struct {
+	description string
+	up, down    func(tx *gorm.DB) error
+}
+
+// All the migrations, in order. A migration's version is its position in the
+// list, starting from one. Never change or remove a migration once it has been
+// released, add another one instead.
+var migrations = []migration{
+	{"create tables", createTables, dropTables},
+	{"index match_type rather than match_mode for idx_match_type", fixMatchTypeIndex, unfixMatchTypeIndex},
+	{"normalize matches saved before the normalized tables existed", backfillNormalizedRows, noop},
+	{"create table for compression dictionaries", createBlobDictionaries, dropBlobDictionaries},
+	{"create table for archived API responses", createApiResponses, dropApiResponses},
+	{"add refreshed to summoners", addSummonerRefreshed, dropSummonerRefreshed},
+	{"create tables for quarantined and pending matches", createQuarantine, dropQuarantine},
+	{"index match creation and version for queries", createQueryIndices, dropQueryIndices},
+	{"create tables for champion stats", createChampionStats, dropChampionStats},
+	{"add retry to crawl checkpoints", addCheckpointRetry, dropCheckpointRetry},
+	{"add attempts to pending matches", addPendingMatchAttempts, dropPendingMatchAttempts},
+}
+
+// The tables and their indices, as they were before migrations were added.
+// Databases created before then already have them so they must not change.
+var (
+	baseTables = []interface{}{&summonerV1{}, &marshaledMatchDetailV1{}, &watchedSummonerV1{},
+		&failedDeliveryV1{}, &crawlCheckpointV1{}, &pendingSummonerV1{}, &matchParticipantV1{},
+		&matchTeamV1{}, &matchBanV1{}, &matchRuneV1{}, &matchMasteryV1{}, &matchEventV1{},
+		&matchEventAssistV1{}, &matchFrameV1{}}
+
+	baseIndices = []struct {
+		model   interface{}
+		unique  bool
+		name    string
+		columns []string
+	}{
+		{&summonerV1{}, true, "idx_name", []string{"name"}},
+		{&summonerV1{}, false, "idx_last_crawled", []string{"last_crawled"}},
+		{&summonerV1{}, false, "idx_claimed_by", []string{"claimed_by"}},
+		{&marshaledMatchDetailV1{}, false, "idx_match_mode", []string{"match_mode"}},
+		{&marshaledMatchDetailV1{}, false, "idx_match_type", []string{"match_mode"}},
+		{&marshaledMatchDetailV1{}, false, "idx_match_queue_type", []string{"queue_type"}},
+		{&marshaledMatchDetailV1{}, false, "idx_match_season", []string{"season"}},
+		{&matchParticipantV1{}, false, "idx_participant_match_id", []string{"match_id"}},
+		{&matchParticipantV1{}, false, "idx_participant_summoner_id", []string{"summoner_id"}},
+		{&matchParticipantV1{}, false, "idx_participant_champion_id", []string{"champion_id"}},
+		{&matchTeamV1{}, false, "idx_team_match_id", []string{"match_id"}},
+		{&matchBanV1{}, false, "idx_ban_match_id", []string{"match_id"}},
+		{&matchBanV1{}, false, "idx_ban_champion_id", []string{"champion_id"}},
+		{&matchRuneV1{}, false, "idx_rune_match_id", []string{"match_id"}},
+		{&matchMasteryV1{}, false, "idx_mastery_match_id", []string{"match_id"}},
+		{&matchEventV1{}, false, "idx_event_match_id", []string{"match_id", "sequence"}},
+		{&matchEventV1{}, false, "idx_event_type", []string{"event_type", "monster_type"}},
+		{&matchEventAssistV1{}, false, "idx_event_assist_match_id", []string{"match_id", "sequence"}},
+		{&matchFrameV1{}, false, "idx_frame_match_id", []string{"match_id", "participant_id"}},
+	}
+)
+
+// Create the tables and indices, unless they already exist.
+func createTables(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(baseTables...).Error; err != nil {
+		return err
+	}
+
+	for _, index := range baseIndices {
+		if err := createIndex(tx, index.model, index.unique, index.name, index.columns...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dropTables(tx *gorm.DB) error {
+	for _, model := range baseTables {
+		if err := tx.DropTableIfExists(model).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fixMatchTypeIndex(tx *gorm.DB) error {
+	if err := dropIndex(tx, "idx_match_type"); err != nil {
+		return err
+	}
+
+	return createIndex(tx, &marshaledMatchDetailV1{}, false, "idx_match_type", "match_type")
+}
+
+func unfixMatchTypeIndex(tx *gorm.DB) error {
+	if err := dropIndex(tx, "idx_match_type"); err != nil {
+		return err
+	}
+
+	return createIndex(tx, &marshaledMatchDetailV1{}, false, "idx_match_type", "match_mode")
+}
+
+// Fill in the normalized tables for matches that were saved before they
+// existed, from the JSON blobs.
+func backfillNormalizedRows(tx *gorm.DB) error {
+	var ids []int64
+	err := tx.Model(&marshaledMatchDetailV1{}).
+		Where("NOT EXISTS (SELECT 1 FROM match_participants p WHERE p.match_id = marshaled_match_details.id)").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		res := &marshaledMatchDetailV1{}
+		if err := tx.First(res, id).Error; err != nil {
+			return err
+		}
+
+		// Leave matches that can't be decoded for fsck to quarantine rather
+		// than stopping the database from being opened
+		rows, err := normalizedRowsV3(res)
+		if err != nil {
+			log.Printf("Unable to unmarshal match details, skipping: %d -- %s", id, err.Error())
+			continue
+		}
+
+		for _, row := range rows {
+			if err := tx.Create(row).Error; err != nil {
+				return fmt.Errorf("Unable to save normalized match details: %d -- %s", id, err.Error())
+			}
+		}
+
+		if (i+1)%1000 == 0 {
+			log.Printf("Normalized %d of %d matches", i+1, len(ids))
+		}
+	}
+
+	return nil
+}
+
+// Normalize a match into rows for the version 1 tables, ordered by table.
+func normalizedRowsV3(res *marshaledMatchDetailV1) ([]interface{}, error) {
+	var identities []participantIdentityV3
+	var participants []participantV3
+	var teams []teamV3
+	var timeline timelineV3
+
+	fields := []struct {
+		src   []byte
+		value interface{}
+	}{
+		{res.ParticipantIdentities, &identities},
+		{res.Participants, &participants},
+		{res.Teams, &teams},
+		{res.Timeline, &timeline},
+	}
+
+	for _, field := range fields {
+		if err := json.Unmarshal(field.src, field.value); err != nil {
+			return nil, err
+		}
+	}
+
+	var participantRows, teamRows, banRows, runeRows, masteryRows []interface{}
+
+	for _, p := range participants {
+		row := p.Stats
+		row.Id = 0
+		row.MatchId = res.Id
+		row.ParticipantId = p.ParticipantId
+		row.ChampionId = p.ChampionId
+		row.TeamId = p.TeamId
+		row.Spell1Id, row.Spell2Id = p.Spell1Id, p.Spell2Id
+		row.Lane, row.Role = p.Timeline.Lane, p.Timeline.Role
+
+		for _, identity := range identities {
+			if identity.ParticipantId == p.ParticipantId {
+				row.SummonerId = identity.Player.SummonerId
+				row.SummonerName = identity.Player.SummonerName
+			}
+		}
+
+		participantRows = append(participantRows, &row)
+
+		for _, r := range p.Runes {
+			runeRows = append(runeRows, &matchRuneV1{MatchId: res.Id, ParticipantId: p.ParticipantId, RuneId: r.RuneId, Rank: r.Rank})
+		}
+
+		for _, m := range p.Masteries {
+			masteryRows = append(masteryRows, &matchMasteryV1{MatchId: res.Id, ParticipantId: p.ParticipantId, MasteryId: m.MasteryId, Rank: m.Rank})
+		}
+	}
+
+	for _, t := range teams {
+		row := t.matchTeamV1
+		row.Id, row.MatchId = 0, res.Id
+		teamRows = append(teamRows, &row)
+
+		for _, b := range t.Bans {
+			banRows = append(banRows, &matchBanV1{MatchId: res.Id, TeamId: t.TeamId, ChampionId: b.ChampionId, PickTurn: b.PickTurn})
+		}
+	}
+
+	var eventRows, assistRows, frameRows []interface{}
+
+	sequence := 0
+	for _, frame := range timeline.Frames {
+		for _, e := range frame.Events {
+			row := e.matchEventV1
+			row.Id, row.MatchId, row.Sequence = 0, res.Id, sequence
+			row.PositionX, row.PositionY = e.Position.X, e.Position.Y
+			eventRows = append(eventRows, &row)
+
+			for _, id := range e.AssistingParticipantIds {
+				assistRows = append(assistRows, &matchEventAssistV1{MatchId: res.Id, Sequence: sequence, ParticipantId: id})
+			}
+
+			sequence++
+		}
+
+		for _, p := range frame.ParticipantFrames {
+			row := p.matchFrameV1
+			row.Id, row.MatchId, row.Timestamp = 0, res.Id, frame.Timestamp
+			row.PositionX, row.PositionY = p.Position.X, p.Position.Y
+			frameRows = append(frameRows, &row)
+		}
+	}
+
+	rows := append(participantRows, teamRows...)
+	rows = append(rows, banRows...)
+	rows = append(rows, runeRows...)
+	rows = append(rows, masteryRows...)
+	rows = append(rows, eventRows...)
+	rows = append(rows, assistRows...)
+	return append(rows, frameRows...), nil
+}
+
+func createBlobDictionaries(tx *gorm.DB) error {
+	return tx.AutoMigrate(&blobDictionaryV4{}).Error
+}
+
+func dropBlobDictionaries(tx *gorm.DB) error {
+	return tx.DropTableIfExists(&blobDictionaryV4{}).Error
+}
+
+func createApiResponses(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&apiResponseV5{}).Error; err != nil {
+		return err
+	}
+
+	return createIndex(tx, &apiResponseV5{}, false, "idx_response_resource", "endpoint", "resource_id")
+}
+
+func dropApiResponses(tx *gorm.DB) error {
+	return tx.DropTableIfExists(&apiResponseV5{}).Error
+}
+
+func addSummonerRefreshed(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&summonerV6{}).Error; err != nil {
+		return err
+	}
+
+	return createIndex(tx, &summonerV6{}, false, "idx_refreshed", "refreshed")
+}
+
+func dropSummonerRefreshed(tx *gorm.DB) error {
+	if err := dropIndex(tx, "idx_refreshed"); err != nil {
+		return err
+	}
+
+	// Old versions of SQLite can't drop columns, an unused column is harmless
+	if dbDriver(*tx) == _Postgres {
+		return tx.Exec("ALTER TABLE summoners DROP COLUMN refreshed").Error
+	}
+
+	return nil
+}
+
+func createQuarantine(tx *gorm.DB) error {
+	return tx.AutoMigrate(&quarantinedMatchV7{}, &pendingMatchV7{}).Error
+}
+
+func dropQuarantine(tx *gorm.DB) error {
+	return tx.DropTableIfExists(&quarantinedMatchV7{}).DropTableIfExists(&pendingMatchV7{}).Error
+}
+
+func createQueryIndices(tx *gorm.DB) error {
+	if err := createIndex(tx, &marshaledMatchDetailV1{}, false, "idx_match_creation", "match_creation"); err != nil {
+		return err
+	}
+
+	return createIndex(tx, &marshaledMatchDetailV1{}, false, "idx_match_version", "match_version")
+}
+
+func dropQueryIndices(tx *gorm.DB) error {
+	if err := dropIndex(tx, "idx_match_creation"); err != nil {
+		return err
+	}
+
+	return dropIndex(tx, "idx_match_version")
+}
+
+func createChampionStats(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&championStatV9{}, &patchStatV9{}, &aggregatedMatchV9{}).Error; err != nil {
+		return err
+	}
+
+	if err := createIndex(tx, &championStatV9{}, true, "idx_champion_stat", "patch", "queue_type", "lane", "role", "champion_id"); err != nil {
+		return err
+	}
+
+	return createIndex(tx, &patchStatV9{}, true, "idx_patch_stat", "patch", "queue_type")
+}
+
+func dropChampionStats(tx *gorm.DB) error {
+	return tx.DropTableIfExists(&championStatV9{}).DropTableIfExists(&patchStatV9{}).DropTableIfExists(&aggregatedMatchV9{}).Error
+}
+
+func addCheckpointRetry(tx *gorm.DB) error {
+	return tx.AutoMigrate(&crawlCheckpointV10{}).Error
+}
+
+func dropCheckpointRetry(tx *gorm.DB) error {
+	// Old versions of SQLite can't drop columns, an unused column is harmless
+	if dbDriver(*tx) == _Postgres {
+		return tx.Exec("ALTER TABLE crawl_checkpoints DROP COLUMN retry").Error
+	}
+
+	return nil
+}
+
+func addPendingMatchAttempts(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&pendingMatchV11{}).Error; err != nil {
+		return err
+	}
+
+	// The column is added without a default, the matches that were already
+	// pending haven't been attempted yet
+	return tx.Exec("UPDATE pending_matches SET attempts = 0 WHERE attempts IS NULL").Error
+}
+
+func dropPendingMatchAttempts(tx *gorm.DB) error {
+	// Old versions of SQLite can't drop columns, an unused column is harmless
+	if dbDriver(*tx) == _Postgres {
+		return tx.Exec("ALTER TABLE pending_matches DROP COLUMN attempts").Error
+	}
+
+	return nil
+}
+
+// The backfilled rows can't be told apart from the rows saved by the crawler
+// so they're left in place.
+func noop(tx *gorm.DB) error {
+	return nil
+}
+
+func createIndex(tx *gorm.DB, model interface{}, unique bool, name string, columns ...string) error {
+	stmt := "CREATE INDEX IF NOT EXISTS"
+	if unique {
+		stmt = "CREATE UNIQUE INDEX IF NOT EXISTS"
+	}
+
+	table := tx.NewScope(model).TableName()
+
+	return tx.Exec(fmt.Sprintf("%s %s ON %s (%s)", stmt, name, table, strings.Join(columns, ", "))).Error
+}
+
+func dropIndex(tx *gorm.DB, name string) error {
+	return tx.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", name)).Error
+}
+
+// Get the version of the latest migration applied to the database, zero if
+// there aren't any.
+func schemaVersion(db gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaVersion{}).Error; err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := db.Model(&SchemaVersion{}).Select("MAX(id)").Row().Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// Apply or revert migrations until the database is at the target version.
+// Each migration runs in its own transaction.
+func migrateDB(db gorm.DB, target int) error {
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("unknown schema version: %d", target)
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for ; version < target; version++ {
+		m := migrations[version]
+		log.Printf("Applying migration %d: %s", version+1, m.description)
+
+		if err := runMigration(db, m.up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaVersion{Id: version + 1, Description: m.description, Applied: time.Now().UnixNano()}).Error
+		}); err != nil {
+			return fmt.Errorf("Unable to apply migration: %d -- %s", version+1, err.Error())
+		}
+	}
+
+	for ; version > target; version-- {
+		m := migrations[version-1]
+		log.Printf("Reverting migration %d: %s", version, m.description)
+
+		if err := runMigration(db, m.down, func(tx *gorm.DB) error {
+			return tx.Delete(&SchemaVersion{Id: version}).Error
+		}); err != nil {
+			return fmt.Errorf("Unable to revert migration: %d -- %s", version, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Run a migration step and record it in a single transaction.
+func runMigration(db gorm.DB, step, record func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+
+	err := step(tx)
+	if err == nil {
+		err = record(tx)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func migrateCommand(db gorm.DB, args []string) error {
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+
+	switch args[0] {
+	case "status":
+		return migrateStatus(db, os.Stdout)
+	case "up":
+		target := len(migrations)
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			target = v
+		}
+
+		return migrateDB(db, target)
+	case "down":
+		if len(args) != 2 {
+			return errors.New("expected version to revert to")
+		}
+
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+
+		return migrateDB(db, target)
+	}
+
+	return fmt.Errorf("unknown migrate command: %s", args[0])
+}
+
+func migrateStatus(db gorm.DB, w io.Writer) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "schema version %d of %d\n", version, len(migrations))
+
+	for i, m := range migrations {
+		status := "pending"
+		if i < version {
+			status = "applied"
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\n", i+1, status, m.description)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jcrussell/crawlol/model"
+)
+
+func TestMigrate(t *testing.T) {
+	db, err := connectDB(":memory:")
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer db.Close()
+
+	if err := migrateDB(db, 1); err != nil {
+		t.Fatalf("Unable to migrate database: %s", err.Error())
+	}
+
+	// Save matches like the crawler did before the normalized tables existed,
+	// when the blobs were plain JSON. A match that can't be decoded shouldn't
+	// stop the backfill.
+	for _, id := range []int64{700, 701} {
+		match := testHookMatch(id, "RANKED_SOLO_5x5")
+
+		res := &marshaledMatchDetailV1{Id: id, QueueType: match.QueueType}
+		res.ParticipantIdentities, _ = json.Marshal(match.ParticipantIdentities)
+		res.Participants, _ = json.Marshal(match.Participants)
+		res.Teams, _ = json.Marshal(match.Teams)
+		res.Timeline, _ = json.Marshal(match.Timeline)
+
+		if id == 701 {
+			res.Participants = []byte("{not json")
+		}
+
+		if err := db.Create(res).Error; err != nil {
+			t.Fatalf("Unable to save match: %s", err.Error())
+		}
+	}
+
+	if err := migrateDB(db, len(migrations)); err != nil {
+		t.Fatalf("Unable to migrate database: %s", err.Error())
+	}
+
+	if version, _ := schemaVersion(db); version != len(migrations) {
+		t.Errorf("Expected schema version %d, got %d", len(migrations), version)
+	}
+
+	var index string
+	db.Raw("SELECT sql FROM sqlite_master WHERE name = ?", "idx_match_type").Row().Scan(&index)
+	if !strings.Contains(index, "match_type") {
+		t.Errorf("Expected idx_match_type on match_type, got %s", index)
+	}
+
+	var participants []MatchParticipant
+	db.Where("match_id = ?", 700).Find(&participants)
+	if len(participants) != 1 {
+		t.Fatalf("Expected match to be normalized, got %d participants", len(participants))
+	}
+
+	if p := participants[0]; p.SummonerId != 100 || p.ChampionId != 103 || p.TeamId != 100 || !p.Winner {
+		t.Errorf("Expected participant to be filled in from the match, got %#v", p)
+	}
+
+	// The migrations should have created a column for every field of the models
+	models := append([]interface{}{&Summoner{}, &MarshaledMatchDetail{}, &WatchedSummoner{}, &FailedDelivery{},
+		&CrawlCheckpoint{}, &PendingSummoner{}, &BlobDictionary{}, &ApiResponse{}, &QuarantinedMatch{},
+		&PendingMatch{}, &ChampionStat{}, &PatchStat{}, &AggregatedMatch{}}, model.MatchTables...)
+	for _, model := range models {
+		scope := db.NewScope(model)
+		for name, field := range scope.Fields() {
+			if field.IsNormal && !scope.Dialect().HasColumn(scope, scope.TableName(), name) {
+				t.Errorf("Expected column %s in %s", name, scope.TableName())
+			}
+		}
+	}
+
+	// All the way back down should remove everything
+	if err := migrateDB(db, 0); err != nil {
+		t.Fatalf("Unable to revert migrations: %s", err.Error())
+	}
+
+	if db.HasTable(&Summoner{}) {
+		t.Error("Expected tables to be dropped")
+	}
+}
@@ -0,0 +1,181 @@
+package model
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// The JSON blobs in MarshaledMatchDetail are compressed when they are saved.
+// The first bytes of a blob say how it was compressed so that blobs saved
+// before compression was added, or with a different setting, still decode:
+//
+//	0x1f 0x8b                  gzip
+//	0x00 'D' <uvarint id> ...  deflate with the preset dictionary id
+//	anything else              uncompressed JSON
+
+// Compression methods for new blobs.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressDict = "dict"
+)
+
+var _DictMagic = []byte{0x00, 'D'}
+
+// BlobDictionary is a preset dictionary for deflate, sampled from saved
+// blobs. Dictionaries are never changed or removed once they have been used.
+type BlobDictionary struct {
+	Id      int64  // Auto-incremented ID
+	Data    []byte // Dictionary, at most 32KB
+	Created int64  // When the dictionary was created, specified as epoch nanoseconds
+}
+
+// Codec compresses and decompresses blobs. Load the dictionaries from the
+// database before decoding blobs that were compressed with them.
+type Codec struct {
+	method string           // Compression method for new blobs
+	dictID int64            // Dictionary to use for the dict method
+	dicts  map[int64][]byte // All the known dictionaries, by ID
+}
+
+// NewCodec creates a codec that compresses new blobs with gzip.
+func NewCodec() *Codec {
+	return &Codec{method: CompressGzip, dicts: map[int64][]byte{}}
+}
+
+// Load all the dictionaries from the database so that blobs compressed with
+// them can be decoded.
+func (c *Codec) Load(db gorm.DB) error {
+	if !db.HasTable(&BlobDictionary{}) {
+		return nil
+	}
+
+	var dicts []BlobDictionary
+	if err := db.Find(&dicts).Error; err != nil && err != gorm.RecordNotFound {
+		return err
+	}
+
+	for _, dict := range dicts {
+		c.AddDictionary(dict)
+	}
+
+	return nil
+}
+
+// AddDictionary makes a dictionary that was just created known to the codec.
+func (c *Codec) AddDictionary(dict BlobDictionary) {
+	c.dicts[dict.Id] = dict.Data
+}
+
+// SetMethod sets the compression method for new blobs. The dict method uses
+// the newest dictionary.
+func (c *Codec) SetMethod(method string) error {
+	switch method {
+	case CompressNone, CompressGzip:
+	case CompressDict:
+		c.dictID = 0
+		for id := range c.dicts {
+			if id > c.dictID {
+				c.dictID = id
+			}
+		}
+
+		if c.dictID == 0 {
+			return errors.New("no dictionaries, create one with blobs train")
+		}
+	default:
+		return fmt.Errorf("unknown compression method: %s", method)
+	}
+
+	c.method = method
+	return nil
+}
+
+// Compress a blob with the codec's method.
+func (c *Codec) Compress(buf []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch c.method {
+	case CompressNone:
+		return buf, nil
+	case CompressGzip:
+		w, err = gzip.NewWriterLevel(&out, gzip.BestCompression)
+	case CompressDict:
+		out.Write(_DictMagic)
+
+		id := make([]byte, binary.MaxVarintLen64)
+		out.Write(id[:binary.PutUvarint(id, uint64(c.dictID))])
+
+		w, err = flate.NewWriterDict(&out, flate.BestCompression, c.dicts[c.dictID])
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// Decompress a blob, however it was compressed.
+func (c *Codec) Decompress(buf []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+
+	switch {
+	case bytes.HasPrefix(buf, []byte{0x1f, 0x8b}):
+		r, err = gzip.NewReader(bytes.NewReader(buf))
+	case bytes.HasPrefix(buf, _DictMagic):
+		br := bytes.NewReader(buf[len(_DictMagic):])
+
+		id, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+
+		dict, ok := c.dicts[int64(id)]
+		if !ok {
+			return nil, fmt.Errorf("unknown dictionary: %d", id)
+		}
+
+		r = flate.NewReaderDict(br, dict)
+	default:
+		return buf, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// BlobMethod gets the name of the method that a blob was compressed with.
+func BlobMethod(buf []byte) string {
+	switch {
+	case bytes.HasPrefix(buf, []byte{0x1f, 0x8b}):
+		return CompressGzip
+	case bytes.HasPrefix(buf, _DictMagic):
+		return CompressDict
+	}
+
+	return CompressNone
+}
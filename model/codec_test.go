@@ -0,0 +1,52 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testTimeline = `{"frameInterval": 60000, "frames": [
+	{"timestamp": 0, "participantFrames": {"1": {"participantId": 1, "currentGold": 475, "totalGold": 475, "xp": 0, "level": 1}}},
+	{"timestamp": 60000, "events": [{"eventType": "CHAMPION_KILL", "timestamp": 55000, "killerId": 1, "victimId": 6}]}
+]}`
+
+func TestBlobRoundTrip(t *testing.T) {
+	codec := NewCodec()
+
+	match := &MatchDetail{
+		Participants: []Participant{{ParticipantID: 1, ChampionID: 103}},
+	}
+	if err := json.Unmarshal([]byte(testTimeline), &match.Timeline); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs saved before compression was added are plain JSON
+	raw, _ := json.Marshal(match.Timeline)
+	if buf, err := codec.Decompress(raw); err != nil || string(buf) != string(raw) {
+		t.Errorf("Expected uncompressed blob to decode as is, got %s (%v)", buf, err)
+	}
+
+	for _, method := range []string{CompressNone, CompressGzip} {
+		if err := codec.SetMethod(method); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := match.Marshal(codec)
+		if err != nil {
+			t.Fatalf("Unable to marshal match: %s", err.Error())
+		}
+
+		if BlobMethod(res.Timeline) != method {
+			t.Errorf("Expected %s blob, got %s", method, BlobMethod(res.Timeline))
+		}
+
+		out, err := res.Unmarshal(codec)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal %s match: %s", method, err.Error())
+		}
+
+		if len(out.Timeline.Frames) != 2 || out.Participants[0].ChampionID != 103 {
+			t.Errorf("Unexpected %s match after round trip: %#v", method, out)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package model
+
+import "encoding/json"
+
+// Marshal converts a MatchDetail into a MarshaledMatchDetail. Marshals all the
+// fields that aren't basic types like strings and integers and compresses them
+// with the codec.
+func (v *MatchDetail) Marshal(codec *Codec) (*MarshaledMatchDetail, error) {
+	e := MarshaledMatchDetail{(*v).BaseMatchDetail, nil, nil, nil, nil}
+
+	fields := []struct {
+		value interface{}
+		dst   *[]byte
+	}{
+		{v.ParticipantIdentities, &e.ParticipantIdentities},
+		{v.Participants, &e.Participants},
+		{v.Teams, &e.Teams},
+		{v.Timeline, &e.Timeline},
+	}
+
+	for _, field := range fields {
+		buf, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+
+		if *field.dst, err = codec.Compress(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return &e, nil
+}
+
+// Unmarshal converts a MarshaledMatchDetail back into a MatchDetail.
+// Unmarshals all the fields that aren't basic types like strings and integers,
+// decompressing them with the codec first if necessary.
+func (v *MarshaledMatchDetail) Unmarshal(codec *Codec) (*MatchDetail, error) {
+	e := MatchDetail{(*v).BaseMatchDetail, nil, nil, nil, Timeline{}}
+
+	fields := []struct {
+		src   []byte
+		value interface{}
+	}{
+		{v.ParticipantIdentities, &e.ParticipantIdentities},
+		{v.Participants, &e.Participants},
+		{v.Teams, &e.Teams},
+		{v.Timeline, &e.Timeline},
+	}
+
+	for _, field := range fields {
+		buf, err := codec.Decompress(field.src)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(buf, field.value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &e, nil
+}
+
+// NormalizedRows normalizes the match into rows for each of the MatchTables.
+// The rows are pointers to the models, ordered by table.
+func (v *MatchDetail) NormalizedRows() []interface{} {
+	var participants, runes, masteries, teams, bans []interface{}
+
+	for _, p := range v.Participants {
+		row := &MatchParticipant{
+			MatchId:          v.Id,
+			ParticipantId:    p.ParticipantID,
+			ChampionId:       p.ChampionID,
+			TeamId:           p.TeamID,
+			Spell1Id:         p.Spell1ID,
+			Spell2Id:         p.Spell2ID,
+			Lane:             p.Timeline.Lane,
+			Role:             p.Timeline.Role,
+			ParticipantStats: p.Stats,
+		}
+
+		for _, identity := range v.ParticipantIdentities {
+			if identity.ParticipantID == p.ParticipantID {
+				row.SummonerId = identity.Player.SummonerID
+				row.SummonerName = identity.Player.SummonerName
+			}
+		}
+
+		participants = append(participants, row)
+
+		for _, r := range p.Runes {
+			runes = append(runes, &MatchRune{MatchId: v.Id, ParticipantId: p.ParticipantID, RuneId: r.RuneID, Rank: r.Rank})
+		}
+
+		for _, m := range p.Masteries {
+			masteries = append(masteries, &MatchMastery{MatchId: v.Id, ParticipantId: p.ParticipantID, MasteryId: m.MasteryID, Rank: m.Rank})
+		}
+	}
+
+	for _, t := range v.Teams {
+		teams = append(teams, &MatchTeam{
+			MatchId:              v.Id,
+			TeamId:               t.TeamID,
+			BaronKills:           t.BaronKills,
+			DominionVictoryScore: t.DominionVictoryScore,
+			DragonKills:          t.DragonKills,
+			FirstBaron:           t.FirstBaron,
+			FirstBlood:           t.FirstBlood,
+			FirstDragon:          t.FirstDragon,
+			FirstInhibitor:       t.FirstInhibitor,
+			FirstTower:           t.FirstTower,
+			InhibitorKills:       t.InhibitorKills,
+			TowerKills:           t.TowerKills,
+			VilemawKills:         t.VilemawKills,
+			Winner:               t.Winner,
+		})
+
+		for _, b := range t.Bans {
+			bans = append(bans, &MatchBan{MatchId: v.Id, TeamId: t.TeamID, ChampionId: b.ChampionID, PickTurn: b.PickTurn})
+		}
+	}
+
+	res := append(participants, teams...)
+	res = append(res, bans...)
+	res = append(res, runes...)
+	res = append(res, masteries...)
+	return append(res, v.TimelineRows()...)
+}
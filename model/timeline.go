@@ -0,0 +1,119 @@
+package model
+
+// MatchEvent is an event from a match's timeline, such as a kill, an item
+// purchase or a ward being placed. Fields that aren't relevant to the type of
+// event are left blank.
+type MatchEvent struct {
+	Id            int64  // Auto-incremented ID
+	MatchId       int64  // ID of the match
+	Sequence      int    // Position of the event in the match's timeline
+	Timestamp     int64  // Milliseconds into the game that the event occurred
+	EventType     string // Event type
+	ParticipantId int    // Participant ID
+	KillerId      int    // Killer ID
+	VictimId      int    // Victim ID
+	CreatorId     int    // Creator ID
+	TeamId        int    // Team ID
+	PositionX     int    // X coordinate of the event
+	PositionY     int    // Y coordinate of the event
+	ItemId        int    // Item ID
+	ItemBefore    int    // Starting item ID
+	ItemAfter     int    // Ending item ID
+	SkillSlot     int    // Skill slot
+	LevelUpType   string // Level up type
+	WardType      string // Ward type
+	BuildingType  string // Building type
+	TowerType     string // Tower type
+	LaneType      string // Lane type
+	MonsterType   string // Monster type
+	AscendedType  string // Ascended type
+	PointCaptured string // Point captured
+}
+
+// MatchEventAssist is a participant that assisted in an event, joined to the
+// event on MatchId and Sequence.
+type MatchEventAssist struct {
+	Id            int64 // Auto-incremented ID
+	MatchId       int64 // ID of the match
+	Sequence      int   // Position of the event in the match's timeline
+	ParticipantId int   // ID of the assisting participant
+}
+
+// MatchFrame is a snapshot of a participant from a match's timeline, taken
+// once every frame interval (usually a minute).
+type MatchFrame struct {
+	Id                  int64 // Auto-incremented ID
+	MatchId             int64 // ID of the match
+	Timestamp           int64 // Milliseconds into the game that the frame was taken
+	ParticipantId       int   // Participant ID
+	CurrentGold         int   // Participant's current gold
+	TotalGold           int   // Participant's total gold
+	Xp                  int   // Experience earned by participant
+	Level               int   // Participant's current level
+	MinionsKilled       int   // Number of minions killed by participant
+	JungleMinionsKilled int   // Number of jungle minions killed by participant
+	PositionX           int   // X coordinate of the participant
+	PositionY           int   // Y coordinate of the participant
+}
+
+// TimelineRows normalizes the match's timeline into rows for the event, assist
+// and frame tables. The rows are pointers to the models, ordered by table.
+func (v *MatchDetail) TimelineRows() []interface{} {
+	var events, assists, frames []interface{}
+
+	sequence := 0
+	for _, frame := range v.Timeline.Frames {
+		for _, e := range frame.Events {
+			events = append(events, &MatchEvent{
+				MatchId:       v.Id,
+				Sequence:      sequence,
+				Timestamp:     e.Timestamp,
+				EventType:     e.EventType,
+				ParticipantId: e.ParticipantID,
+				KillerId:      e.KillerID,
+				VictimId:      e.VictimID,
+				CreatorId:     e.CreatorID,
+				TeamId:        e.TeamID,
+				PositionX:     e.Position.X,
+				PositionY:     e.Position.Y,
+				ItemId:        e.ItemID,
+				ItemBefore:    e.ItemBefore,
+				ItemAfter:     e.ItemAfter,
+				SkillSlot:     e.SkillSlot,
+				LevelUpType:   e.LevelUpType,
+				WardType:      e.WardType,
+				BuildingType:  e.BuildingType,
+				TowerType:     e.TowerType,
+				LaneType:      e.LaneType,
+				MonsterType:   e.MonsterType,
+				AscendedType:  e.AscendedType,
+				PointCaptured: e.PointCaptured,
+			})
+
+			for _, id := range e.AssistingParticipantIDs {
+				assists = append(assists, &MatchEventAssist{MatchId: v.Id, Sequence: sequence, ParticipantId: id})
+			}
+
+			sequence++
+		}
+
+		for _, p := range frame.ParticipantFrames {
+			frames = append(frames, &MatchFrame{
+				MatchId:             v.Id,
+				Timestamp:           frame.Timestamp,
+				ParticipantId:       p.ParticipantID,
+				CurrentGold:         p.CurrentGold,
+				TotalGold:           p.TotalGold,
+				Xp:                  p.Xp,
+				Level:               p.Level,
+				MinionsKilled:       p.MinionsKilled,
+				JungleMinionsKilled: p.JungleMinionsKilled,
+				PositionX:           p.Position.X,
+				PositionY:           p.Position.Y,
+			})
+		}
+	}
+
+	res := append(events, assists...)
+	return append(res, frames...)
+}
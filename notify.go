@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/feed"
+)
+
+// Create the feed event for a match.
+func matchEvent(match *MatchDetail) feed.Event {
+	event := feed.Event{
+		MatchId:       match.Id,
+		MapId:         match.MapId,
+		MatchCreation: match.MatchCreation,
+		MatchDuration: match.MatchDuration,
+		MatchMode:     match.MatchMode,
+		MatchType:     match.MatchType,
+		MatchVersion:  match.MatchVersion,
+		QueueType:     match.QueueType,
+		Region:        match.Region,
+		Season:        match.Season,
+	}
+
+	for _, identity := range match.ParticipantIdentities {
+		event.SummonerIds = append(event.SummonerIds, identity.Player.SummonerID)
+	}
+
+	return event
+}
+
+// Notify the feed channel that a match has been saved. Notifications are sent
+// by PostgreSQL when the transaction commits so subscribers never hear about
+// matches that weren't saved. Does nothing on other databases.
+func notifyMatch(tx *gorm.DB, match *MatchDetail) error {
+	if *notifyChannel == "" || dbDriver(*tx) != _Postgres {
+		return nil
+	}
+
+	payload, err := json.Marshal(matchEvent(match))
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec("SELECT pg_notify(?, ?)", *notifyChannel, string(payload)).Error
+}
+
+// Stream the events from the feed channel to stdout as JSON lines.
+func subscribeCommand(db gorm.DB, args []string) error {
+	if dbDriver(db) != _Postgres {
+		return errors.New("the feed requires a PostgreSQL database")
+	}
+
+	channel := *notifyChannel
+	if len(args) > 0 {
+		channel = args[0]
+	}
+
+	sub, err := feed.Subscribe(*dbPath, channel)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	go func() {
+		for err := range sub.Errors {
+			log.Printf("Feed error, events may have been missed: %s", err.Error())
+		}
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	for event := range sub.Events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
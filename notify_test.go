@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcrussell/crawlol/feed"
+)
+
+func TestMatchEvent(t *testing.T) {
+	event := matchEvent(testHookMatch(400, "RANKED_SOLO_5x5"))
+
+	if event.MatchId != 400 || event.QueueType != "RANKED_SOLO_5x5" {
+		t.Errorf("Unexpected match metadata: %#v", event)
+	}
+
+	if len(event.SummonerIds) != 1 || event.SummonerIds[0] != 100 {
+		t.Errorf("Expected summoner 100 in event, got %v", event.SummonerIds)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	dsn := os.Getenv("POSTGRES")
+	if dsn == "" {
+		t.Skip("POSTGRES is not set")
+	}
+
+	pg, err := openDB(dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer pg.Close()
+
+	sub, err := feed.Subscribe(dsn, *notifyChannel)
+	if err != nil {
+		t.Fatalf("Unable to subscribe: %s", err.Error())
+	}
+	defer sub.Close()
+
+	pg.Where("id = ?", 401).Delete(&MarshaledMatchDetail{})
+	pg.Where("match_id = ?", 401).Delete(&MatchParticipant{})
+
+	if err := saveMatch(pg, testHookMatch(401, "RANKED_SOLO_5x5")); err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+
+	select {
+	case event := <-sub.Events:
+		if event.MatchId != 401 {
+			t.Errorf("Expected event for match 401, got %#v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out waiting for event")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Minimal Parquet writer for flat tables of required columns. Values are
+// buffered by column and written out as a row group, with one PLAIN encoded,
+// uncompressed data page per column, every _ParquetRowGroupSize rows so that
+// only one row group is held in memory. This is enough for pandas, Spark and
+// friends to read without pulling in a full Parquet library.
+
+const _ParquetMagic = "PAR1"
+
+// Number of rows buffered before they are written out as a row group.
+const _ParquetRowGroupSize = 10000
+
+// Parquet physical types
+const (
+	_ParquetBoolean   = 0
+	_ParquetInt64     = 2
+	_ParquetDouble    = 5
+	_ParquetByteArray = 6
+)
+
+// Thrift compact protocol types
+const (
+	_ThriftI32    = 5
+	_ThriftI64    = 6
+	_ThriftBinary = 8
+	_ThriftList   = 9
+	_ThriftStruct = 12
+)
+
+type parquetColumn struct {
+	name   string
+	typ    int32
+	n      int
+	values bytes.Buffer
+	bits   byte // Number of bits used in the last byte of booleans
+}
+
+// Location of a column's data within the file, for the footer.
+type parquetChunk struct {
+	offset, size int64
+	n            int
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+type parquetWriter struct {
+	w         io.Writer
+	columns   []*parquetColumn
+	groupSize int64 // Number of rows per row group
+	groups    []parquetRowGroup
+	buffered  int64 // Number of rows in the current row group
+	rows      int64
+	offset    int64 // Number of bytes written so far
+}
+
+// Create a Parquet writer with a column for each of the export columns.
+func newParquetWriter(w io.Writer, columns []exportColumn) *parquetWriter {
+	res := &parquetWriter{w: w, groupSize: _ParquetRowGroupSize}
+
+	for _, col := range columns {
+		c := &parquetColumn{name: col.name}
+		switch col.kind {
+		case reflect.Bool:
+			c.typ = _ParquetBoolean
+		case reflect.Float32, reflect.Float64:
+			c.typ = _ParquetDouble
+		case reflect.String:
+			c.typ = _ParquetByteArray
+		default:
+			c.typ = _ParquetInt64
+		}
+
+		res.columns = append(res.columns, c)
+	}
+
+	return res
+}
+
+func (w *parquetWriter) write(values []interface{}) error {
+	for i, v := range values {
+		c := w.columns[i]
+
+		switch v := v.(type) {
+		case bool:
+			// Booleans are bit-packed, least significant bit first
+			if c.bits == 0 {
+				c.values.WriteByte(0)
+			}
+			if v {
+				buf := c.values.Bytes()
+				buf[len(buf)-1] |= 1 << c.bits
+			}
+			c.bits = (c.bits + 1) % 8
+		case float64:
+			binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+		case string:
+			binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+			c.values.WriteString(v)
+		case int64:
+			binary.Write(&c.values, binary.LittleEndian, v)
+		}
+
+		c.n++
+	}
+
+	w.rows++
+	w.buffered++
+
+	if w.buffered >= w.groupSize {
+		return w.flush()
+	}
+
+	return nil
+}
+
+// Write the magic number that starts the file, if it hasn't been already.
+func (w *parquetWriter) start() error {
+	if w.offset > 0 {
+		return nil
+	}
+
+	return w.emit([]byte(_ParquetMagic))
+}
+
+// Write bytes to the file, keeping track of the offset.
+func (w *parquetWriter) emit(buf []byte) error {
+	n, err := w.w.Write(buf)
+	w.offset += int64(n)
+
+	return err
+}
+
+// Write the buffered rows as a row group and reset the columns.
+func (w *parquetWriter) flush() error {
+	if w.buffered == 0 {
+		return nil
+	}
+
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	group := parquetRowGroup{rows: w.buffered}
+
+	for _, c := range w.columns {
+		header := &thriftWriter{}
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(c.values.Len()))
+		header.i32(3, int32(c.values.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(c.n))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE
+		header.i32(4, 3) // RLE
+		header.endStruct()
+		header.stop()
+
+		chunk := parquetChunk{offset: w.offset, n: c.n}
+		if err := w.emit(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := w.emit(c.values.Bytes()); err != nil {
+			return err
+		}
+		chunk.size = w.offset - chunk.offset
+
+		group.chunks = append(group.chunks, chunk)
+
+		c.values.Reset()
+		c.n, c.bits = 0, 0
+	}
+
+	w.groups = append(w.groups, group)
+	w.buffered = 0
+
+	return nil
+}
+
+// Write any buffered rows and the footer.
+func (w *parquetWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+
+	// Schema is a root element followed by one element per column
+	meta.beginList(2, _ThriftStruct, len(w.columns)+1)
+	meta.beginElem()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(w.columns)))
+	meta.endStruct()
+	for _, c := range w.columns {
+		meta.beginElem()
+		meta.i32(1, c.typ)
+		meta.i32(3, 0) // REQUIRED
+		meta.binary(4, c.name)
+		if c.typ == _ParquetByteArray {
+			meta.i32(6, 0) // UTF8
+		}
+		meta.endStruct()
+	}
+
+	meta.i64(3, w.rows)
+
+	meta.beginList(4, _ThriftStruct, len(w.groups))
+	for _, group := range w.groups {
+		var total int64
+		for _, chunk := range group.chunks {
+			total += chunk.size
+		}
+
+		meta.beginElem()
+		meta.beginList(1, _ThriftStruct, len(w.columns))
+		for i, c := range w.columns {
+			chunk := group.chunks[i]
+
+			meta.beginElem()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, c.typ)
+			meta.beginList(2, _ThriftI32, 2)
+			meta.varint(0) // PLAIN
+			meta.varint(3) // RLE
+			meta.beginList(3, _ThriftBinary, 1)
+			meta.string(c.name)
+			meta.i32(4, 0) // UNCOMPRESSED
+			meta.i64(5, int64(chunk.n))
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, total)
+		meta.i64(3, group.rows)
+		meta.endStruct()
+	}
+
+	meta.binary(6, "crawlol")
+	meta.stop()
+
+	buf := &bytes.Buffer{}
+	buf.Write(meta.buf.Bytes())
+	binary.Write(buf, binary.LittleEndian, uint32(meta.buf.Len()))
+	buf.WriteString(_ParquetMagic)
+
+	return w.emit(buf.Bytes())
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol, as used by
+// the Parquet footer and page headers.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // Last field ID written in each enclosing struct
+	id   int16   // Last field ID written in the current struct
+}
+
+func (w *thriftWriter) varint(v int64) {
+	// Zigzag encode so that small negative numbers stay small
+	w.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.buf.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (w *thriftWriter) string(v string) {
+	w.uvarint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.id; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+
+	w.id = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, _ThriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, _ThriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) binary(id int16, v string) {
+	w.field(id, _ThriftBinary)
+	w.string(v)
+}
+
+func (w *thriftWriter) beginList(id int16, elem byte, n int) {
+	w.field(id, _ThriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		w.buf.WriteByte(0xf0 | elem)
+		w.uvarint(uint64(n))
+	}
+}
+
+// Start a struct that is a field of the current struct.
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, _ThriftStruct)
+	w.beginElem()
+}
+
+// Start a struct that is an element of a list.
+func (w *thriftWriter) beginElem() {
+	w.last = append(w.last, w.id)
+	w.id = 0
+}
+
+func (w *thriftWriter) endStruct() {
+	w.stop()
+	w.id = w.last[len(w.last)-1]
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) stop() {
+	w.buf.WriteByte(0)
+}
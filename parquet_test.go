@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes the subset of the Thrift compact protocol written by
+// thriftWriter. Fields are returned by ID with integers as int64, binaries as
+// []byte, lists as []interface{} and structs as map[int16]interface{}. It
+// panics on malformed input.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case _ThriftI32, _ThriftI64:
+		return r.varint()
+	case _ThriftBinary:
+		n := int(r.uvarint())
+		r.pos += n
+		return r.buf[r.pos-n : r.pos]
+	case _ThriftList:
+		header := r.buf[r.pos]
+		r.pos++
+
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+
+		var res []interface{}
+		for i := 0; i < n; i++ {
+			res = append(res, r.value(header&0x0f))
+		}
+		return res
+	case _ThriftStruct:
+		return r.readStruct()
+	}
+
+	panic(fmt.Sprintf("unexpected thrift type: %d", typ))
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	res := map[int16]interface{}{}
+
+	var id int16
+	for {
+		header := r.buf[r.pos]
+		r.pos++
+
+		if header == 0 {
+			return res
+		}
+
+		if delta := int16(header >> 4); delta > 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+
+		res[id] = r.value(header & 0x0f)
+	}
+}
+
+// Read back a file written by parquetWriter, returning the column names, the
+// values of each column and the number of rows in each row group.
+func readParquet(t *testing.T, buf []byte) (names []string, columns [][]interface{}, groups []int64) {
+	defer func() {
+		if err := recover(); err != nil {
+			t.Fatalf("Invalid parquet file: %v", err)
+		}
+	}()
+
+	n := len(buf)
+	if n < 12 || string(buf[:4]) != _ParquetMagic || string(buf[n-4:]) != _ParquetMagic {
+		t.Fatalf("Invalid parquet file: missing magic")
+	}
+
+	footer := int(binary.LittleEndian.Uint32(buf[n-8 : n-4]))
+	meta := (&thriftReader{buf: buf[:n-8], pos: n - 8 - footer}).readStruct()
+
+	var types []int64
+	for _, elem := range meta[2].([]interface{})[1:] {
+		elem := elem.(map[int16]interface{})
+		types = append(types, elem[1].(int64))
+		names = append(names, string(elem[4].([]byte)))
+	}
+
+	columns = make([][]interface{}, len(names))
+
+	var rows int64
+	for _, group := range meta[4].([]interface{}) {
+		group := group.(map[int16]interface{})
+		groups = append(groups, group[3].(int64))
+		rows += group[3].(int64)
+
+		for i, chunk := range group[1].([]interface{}) {
+			chunk := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+
+			r := &thriftReader{buf: buf, pos: int(chunk[9].(int64))}
+			header := r.readStruct()
+
+			count := int(header[5].(map[int16]interface{})[1].(int64))
+			if count != int(chunk[5].(int64)) || int64(count) != group[3].(int64) {
+				t.Fatalf("Mismatched value counts for %s: %d", names[i], count)
+			}
+
+			page := bytes.NewReader(buf[r.pos : r.pos+int(header[3].(int64))])
+			for j := 0; j < count; j++ {
+				switch types[i] {
+				case _ParquetBoolean:
+					b := buf[r.pos+j/8]
+					columns[i] = append(columns[i], b&(1<<uint(j%8)) != 0)
+				case _ParquetInt64:
+					var v int64
+					binary.Read(page, binary.LittleEndian, &v)
+					columns[i] = append(columns[i], v)
+				case _ParquetDouble:
+					var v uint64
+					binary.Read(page, binary.LittleEndian, &v)
+					columns[i] = append(columns[i], math.Float64frombits(v))
+				case _ParquetByteArray:
+					var size uint32
+					binary.Read(page, binary.LittleEndian, &size)
+					v := make([]byte, size)
+					page.Read(v)
+					columns[i] = append(columns[i], string(v))
+				}
+			}
+		}
+	}
+
+	if rows != meta[3].(int64) {
+		t.Fatalf("Row groups have %d rows, file has %d", rows, meta[3].(int64))
+	}
+
+	return names, columns, groups
+}
+
+func TestParquetWriter(t *testing.T) {
+	columns := []exportColumn{
+		{name: "id", kind: reflect.Int64},
+		{name: "winner", kind: reflect.Bool},
+		{name: "kda", kind: reflect.Float64},
+		{name: "lane", kind: reflect.String},
+	}
+
+	buf := &bytes.Buffer{}
+	w := newParquetWriter(buf, columns)
+	w.groupSize = 4
+
+	var want [][]interface{}
+	for i := int64(0); i < 10; i++ {
+		row := []interface{}{i, i%3 == 0, float64(i) / 2, fmt.Sprintf("lane-%d", i)}
+		if err := w.write(row); err != nil {
+			t.Fatal(err)
+		}
+
+		want = append(want, row)
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, got, groups := readParquet(t, buf.Bytes())
+
+	if !reflect.DeepEqual(names, []string{"id", "winner", "kda", "lane"}) {
+		t.Errorf("Unexpected columns: %v", names)
+	}
+
+	if !reflect.DeepEqual(groups, []int64{4, 4, 2}) {
+		t.Errorf("Expected row groups of 4, 4 and 2 rows, got %v", groups)
+	}
+
+	for i, row := range want {
+		for j, v := range row {
+			if len(got[j]) != len(want) {
+				t.Fatalf("Expected %d values for %s, got %d", len(want), names[j], len(got[j]))
+			}
+
+			if got[j][i] != v {
+				t.Errorf("Row %d, column %s: expected %v, got %v", i, names[j], v, got[j][i])
+			}
+		}
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newParquetWriter(buf, []exportColumn{{name: "id", kind: reflect.Int64}})
+
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, got, groups := readParquet(t, buf.Bytes())
+	if len(names) != 1 || len(got[0]) != 0 || len(groups) != 0 {
+		t.Errorf("Expected one empty column, got %v %v %v", names, got, groups)
+	}
+}
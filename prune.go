@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// retentionPolicy decides which saved data is worth keeping.
+type retentionPolicy struct {
+	timelines time.Duration // Time to keep timelines for, 0 to keep them forever
+	seasons   int           // Number of the most recent seasons to keep matches from, 0 for all
+	queues    []string      // Queue types whose matches are kept forever, timelines and all
+}
+
+// Build the retention policy from the -retain-* flags.
+func flagRetentionPolicy() retentionPolicy {
+	return retentionPolicy{
+		timelines: *retainTimelines,
+		seasons:   int(*retainSeasons),
+		queues:    splitList(*retainQueues),
+	}
+}
+
+// Apply the retention policy. Timelines of matches older than the policy
+// allows are stripped, leaving the base match, and matches from seasons older
+// than the policy allows are deleted. Space is reclaimed afterwards on SQLite
+// if the database uses incremental vacuum, or in all cases if full is set.
+func pruneDatabase(db gorm.DB, policy retentionPolicy, full bool) error {
+	if policy.timelines > 0 {
+		cutoff := time.Now().Add(-policy.timelines).UnixNano() / int64(time.Millisecond)
+
+		n, err := stripTimelines(db, cutoff, policy.queues)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Stripped the timelines from %d matches", n)
+	}
+
+	if policy.seasons > 0 {
+		n, err := dropOldSeasons(db, policy.seasons, policy.queues)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Deleted %d matches from old seasons", n)
+	}
+
+	if dbDriver(db) == _SQLite {
+		return vacuumSQLite(db, full)
+	}
+
+	return nil
+}
+
+// Keep only queue types that aren't kept forever.
+func notKeptQueues(q *gorm.DB, queues []string) *gorm.DB {
+	if len(queues) == 0 {
+		return q
+	}
+
+	return q.Where("queue_type NOT IN (?)", queues)
+}
+
+// Strip the timelines from matches created before cutoff, specified as epoch
+// milliseconds. Returns the number of matches stripped.
+func stripTimelines(db gorm.DB, cutoff int64, queues []string) (int, error) {
+	frames := db.NewScope(&MatchFrame{}).TableName()
+	events := db.NewScope(&MatchEvent{}).TableName()
+
+	// Matches without timeline rows have nothing left to strip
+	var ids []int64
+	q := notKeptQueues(db.Model(&MarshaledMatchDetail{}).Where("match_creation < ?", cutoff), queues)
+	q = q.Where("(id IN (SELECT match_id FROM " + frames + ") OR id IN (SELECT match_id FROM " + events + "))")
+	if err := q.Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	buf, err := json.Marshal(Timeline{})
+	if err != nil {
+		return 0, err
+	}
+
+	stripped, err := blobs.Compress(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	err = eachBatch(db, ids, func(tx *gorm.DB, batch []int64) error {
+		err := tx.Model(&MarshaledMatchDetail{}).Where("id in (?)", batch).UpdateColumn("timeline", stripped).Error
+		for _, model := range []interface{}{&MatchEvent{}, &MatchEventAssist{}, &MatchFrame{}} {
+			if err == nil {
+				err = tx.Where("match_id in (?)", batch).Delete(model).Error
+			}
+		}
+
+		return err
+	})
+
+	return len(ids), err
+}
+
+// Delete the matches that aren't from the most recent seasons. Seasons are
+// ordered by their newest match. Returns the number of matches deleted.
+func dropOldSeasons(db gorm.DB, keep int, queues []string) (int, error) {
+	rows, err := db.Model(&MarshaledMatchDetail{}).Select("season").Group("season").Order("MAX(match_creation) DESC").Rows()
+	if err != nil {
+		return 0, err
+	}
+
+	var seasons []string
+	for rows.Next() {
+		var season string
+		if err := rows.Scan(&season); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		seasons = append(seasons, season)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil || len(seasons) <= keep {
+		return 0, err
+	}
+
+	log.Printf("Keeping matches from seasons: %v", seasons[:keep])
+
+	var ids []int64
+	q := notKeptQueues(db.Model(&MarshaledMatchDetail{}).Where("season NOT IN (?)", seasons[:keep]), queues)
+	if err := q.Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	err = eachBatch(db, ids, func(tx *gorm.DB, batch []int64) error {
+		err := tx.Where("id in (?)", batch).Delete(&MarshaledMatchDetail{}).Error
+		for i := 0; err == nil && i < len(model.MatchTables); i++ {
+			err = tx.Where("match_id in (?)", batch).Delete(model.MatchTables[i]).Error
+		}
+
+		return err
+	})
+
+	return len(ids), err
+}
+
+// Run fn on the IDs in transactions of up to -write-batch IDs.
+func eachBatch(db gorm.DB, ids []int64, fn func(tx *gorm.DB, batch []int64) error) error {
+	size := int(*writeBatch)
+	if size < 1 {
+		size = 1
+	}
+
+	for i := 0; i < len(ids); i += size {
+		ub := i + size
+		if ub > len(ids) {
+			ub = len(ids)
+		}
+
+		tx := db.Begin()
+		if err := fn(tx, ids[i:ub]); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Give the pages freed by pruning back to the filesystem. Databases created
+// with auto_vacuum set to incremental, as new ones are by tuneSQLite, can do
+// this cheaply. Others need a full VACUUM, which also switches them to
+// incremental for next time.
+func vacuumSQLite(db gorm.DB, full bool) error {
+	var mode int
+	if err := db.Raw("PRAGMA auto_vacuum").Row().Scan(&mode); err != nil {
+		return err
+	}
+
+	// 2 is incremental
+	if mode == 2 {
+		return db.Exec("PRAGMA incremental_vacuum").Error
+	}
+
+	if !full {
+		return nil
+	}
+
+	log.Printf("Switching to incremental vacuum, this rewrites the whole database")
+
+	// The new mode only applies to the connection that runs the VACUUM
+	conn, err := db.DB().Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(context.Background(), "VACUUM"); err != nil {
+		return err
+	}
+
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+
+	if mode != 2 {
+		return fmt.Errorf("unable to switch to incremental vacuum, auto_vacuum is %d", mode)
+	}
+
+	return nil
+}
+
+// Prune the database every interval until shutdown or the crawler is done.
+func pruneEvery(db gorm.DB, policy retentionPolicy, interval time.Duration) {
+	for {
+		select {
+		case <-shutdownChan:
+			log.Println("Shutting down pruner")
+			return
+		case <-crawlDone:
+			return
+		case <-time.After(interval):
+		}
+
+		if err := pruneDatabase(db, policy, false); err != nil {
+			log.Printf("Unable to prune database: %s", err.Error())
+		}
+	}
+}
+
+// Apply the retention policy from the -retain-* flags.
+func pruneCommand(db gorm.DB, args []string) error {
+	return pruneDatabase(db, flagRetentionPolicy(), true)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+func TestPruneDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileDB, err := openDB(filepath.Join(dir, "crawlol.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer fileDB.Close()
+
+	var mode int
+	fileDB.Raw("PRAGMA auto_vacuum").Row().Scan(&mode)
+	if mode != 2 {
+		t.Errorf("Expected new database to use incremental vacuum, got %d", mode)
+	}
+
+	day := int64(24 * time.Hour / time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	matches := []struct {
+		id      int64
+		season  string
+		queue   string
+		created int64
+	}{
+		{1700, "SEASON2014", "RANKED_SOLO_5x5", now - 400*day},
+		{1701, "SEASON2014", "RANKED_TEAM_5x5", now - 400*day},
+		{1702, "SEASON2015", "RANKED_SOLO_5x5", now - 30*day},
+		{1703, "SEASON2015", "RANKED_SOLO_5x5", now},
+	}
+
+	for _, m := range matches {
+		match := testHookMatch(m.id, m.queue)
+		match.Season = m.season
+		match.MatchCreation = m.created
+		if err := json.Unmarshal([]byte(testTimeline), &match.Timeline); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := saveMatch(fileDB, match); err != nil {
+			t.Fatalf("Unable to save match: %s", err.Error())
+		}
+	}
+
+	policy := retentionPolicy{timelines: 7 * 24 * time.Hour, seasons: 1, queues: []string{"RANKED_TEAM_5x5"}}
+	if err := pruneDatabase(fileDB, policy, true); err != nil {
+		t.Fatalf("Unable to prune database: %s", err.Error())
+	}
+
+	if hasMatch(fileDB, 1700) {
+		t.Error("Expected match from an old season to be deleted")
+	}
+
+	for _, m := range matches[1:] {
+		row := &MarshaledMatchDetail{}
+		if err := fileDB.First(row, m.id).Error; err != nil {
+			t.Fatalf("Expected match %d to be kept: %s", m.id, err.Error())
+		}
+
+		match, err := row.Unmarshal(blobs)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal match %d: %s", m.id, err.Error())
+		}
+
+		var frames int
+		fileDB.Model(&MatchFrame{}).Where("match_id = ?", m.id).Count(&frames)
+
+		stripped := m.id == 1702
+		if stripped != (len(match.Timeline.Frames) == 0) || stripped != (frames == 0) {
+			t.Errorf("Expected match %d stripped to be %t, got %d frames and %d frame rows", m.id, stripped, len(match.Timeline.Frames), frames)
+		}
+
+		if len(match.Participants) != 1 {
+			t.Errorf("Expected match %d to keep its participants", m.id)
+		}
+	}
+}
+
+func TestVacuumSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "crawlol.db")
+
+	// A database from before tuneSQLite, without auto_vacuum
+	old, err := gorm.Open(_SQLite, _SQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.AutoMigrate(&summonerV1{})
+	old.Close()
+
+	fileDB, err := openDB(path)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+	defer fileDB.Close()
+
+	var mode int
+	fileDB.Raw("PRAGMA auto_vacuum").Row().Scan(&mode)
+	if mode != 0 {
+		t.Fatalf("Expected old database to have auto_vacuum off, got %d", mode)
+	}
+
+	if err := vacuumSQLite(fileDB, false); err != nil {
+		t.Fatalf("Unable to vacuum database: %s", err.Error())
+	}
+
+	fileDB.Raw("PRAGMA auto_vacuum").Row().Scan(&mode)
+	if mode != 0 {
+		t.Errorf("Expected auto_vacuum to be left alone without a full vacuum, got %d", mode)
+	}
+
+	if err := vacuumSQLite(fileDB, true); err != nil {
+		t.Fatalf("Unable to vacuum database: %s", err.Error())
+	}
+
+	fileDB.Raw("PRAGMA auto_vacuum").Row().Scan(&mode)
+	if mode != 2 {
+		t.Errorf("Expected full vacuum to switch to incremental vacuum, got %d", mode)
+	}
+}
+
+func TestPruneEveryStops(t *testing.T) {
+	defer func(done chan struct{}) { crawlDone = done }(crawlDone)
+	crawlDone = make(chan struct{})
+
+	stopped := make(chan struct{})
+	go func() {
+		pruneEvery(db, retentionPolicy{}, time.Hour)
+		close(stopped)
+	}()
+
+	// Like main once crawl returns
+	close(crawlDone)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the pruner to stop when the crawler is done")
+	}
+}
@@ -0,0 +1,242 @@
+// Package query finds the matches saved by crawlol for analyses. Filters are
+// added by chaining calls and all of them must match:
+//
+//	codec := model.NewCodec()
+//	if err := codec.Load(db); err != nil {
+//		...
+//	}
+//
+//	it := query.New(db).Queues("RANKED_SOLO_5x5").VersionPrefix("5.14.").Champion(103).Iter(codec)
+//	for it.Next() {
+//		match := it.Match()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Every filter uses an indexed column, either of the matches or of their
+// normalized participants. Matches are decoded into the models from the
+// model package.
+package query
+
+import (
+	"log"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+)
+
+// Tables that the filters use.
+const (
+	MatchesTable      = "marshaled_match_details"
+	ParticipantsTable = "match_participants"
+)
+
+// DefaultPageSize is the number of matches loaded at a time by an Iter.
+const DefaultPageSize = 100
+
+// Query is a set of filters on the saved matches.
+type Query struct {
+	db       gorm.DB
+	conds    []cond
+	pageSize int
+}
+
+type cond struct {
+	sql  string
+	args []interface{}
+}
+
+// New creates a query that matches all the saved matches in db.
+func New(db gorm.DB) *Query {
+	return &Query{db: db, pageSize: DefaultPageSize}
+}
+
+// PageSize sets the number of matches loaded at a time by Iter.
+func (q *Query) PageSize(n int) *Query {
+	if n < 1 {
+		n = 1
+	}
+
+	q.pageSize = n
+	return q
+}
+
+// Where adds a raw condition on the matches table.
+func (q *Query) Where(sql string, args ...interface{}) *Query {
+	q.conds = append(q.conds, cond{sql, args})
+	return q
+}
+
+// Queues restricts the query to matches from one of the queue types.
+func (q *Query) Queues(queues ...string) *Query {
+	return q.Where("queue_type IN (?)", queues)
+}
+
+// Seasons restricts the query to matches from one of the seasons.
+func (q *Query) Seasons(seasons ...string) *Query {
+	return q.Where("season IN (?)", seasons)
+}
+
+// VersionPrefix restricts the query to matches whose version starts with
+// prefix. Include the trailing dot to select a single patch, "5.14" also
+// matches "5.140".
+func (q *Query) VersionPrefix(prefix string) *Query {
+	if prefix == "" {
+		return q
+	}
+
+	// A range rather than LIKE so that the index can be used
+	end := []byte(prefix)
+	end[len(end)-1]++
+
+	return q.Where("match_version >= ? AND match_version < ?", prefix, string(end))
+}
+
+// Champion restricts the query to matches where someone played the champion.
+func (q *Query) Champion(id int) *Query {
+	return q.Where("id IN (SELECT match_id FROM "+ParticipantsTable+" WHERE champion_id = ?)", id)
+}
+
+// Summoner restricts the query to matches that the summoner played in.
+func (q *Query) Summoner(id int64) *Query {
+	return q.Where("id IN (SELECT match_id FROM "+ParticipantsTable+" WHERE summoner_id = ?)", id)
+}
+
+// CreatedBetween restricts the query to matches created in [from, to). Zero
+// times leave that end open.
+func (q *Query) CreatedBetween(from, to time.Time) *Query {
+	if !from.IsZero() {
+		q.Where("match_creation >= ?", from.UnixNano()/int64(time.Millisecond))
+	}
+
+	if !to.IsZero() {
+		q.Where("match_creation < ?", to.UnixNano()/int64(time.Millisecond))
+	}
+
+	return q
+}
+
+// DurationBetween restricts the query to matches that lasted between min and
+// max, inclusive. Zero durations leave that end open.
+func (q *Query) DurationBetween(min, max time.Duration) *Query {
+	if min > 0 {
+		q.Where("match_duration >= ?", int64(min/time.Second))
+	}
+
+	if max > 0 {
+		q.Where("match_duration <= ?", int64(max/time.Second))
+	}
+
+	return q
+}
+
+// Scope builds the query on the matches table with all the filters applied.
+func (q *Query) Scope() *gorm.DB {
+	scope := q.db.Table(MatchesTable)
+	for _, cond := range q.conds {
+		scope = scope.Where(cond.sql, cond.args...)
+	}
+
+	return scope
+}
+
+// Count counts the matching matches.
+func (q *Query) Count() (int, error) {
+	var n int
+	err := q.Scope().Count(&n).Error
+
+	return n, err
+}
+
+// IDs gets the IDs of the matching matches, in order.
+func (q *Query) IDs() ([]int64, error) {
+	var ids []int64
+	err := q.Scope().Order("id").Pluck("id", &ids).Error
+
+	return ids, err
+}
+
+// Iter iterates over the matching matches, in order of ID, decoding them with
+// codec. The codec must have loaded the dictionaries from db.
+func (q *Query) Iter(codec *model.Codec) *Iter {
+	return &Iter{q: q, codec: codec}
+}
+
+// Iter loads the matches from a query a page at a time so that only a page
+// of matches is in memory at once. Matches that can't be decoded are logged
+// and skipped.
+type Iter struct {
+	q       *Query
+	codec   *model.Codec
+	page    []model.MarshaledMatchDetail // Rows in the current page
+	last    int64                        // ID of the last match loaded
+	done    bool                         // All the pages have been loaded
+	index   int                          // Index of the current row in page
+	current *model.MatchDetail
+	err     error
+}
+
+// Next moves to the next match, returning false when there are none left or
+// there was an error.
+func (it *Iter) Next() bool {
+	for {
+		if it.err != nil {
+			return false
+		}
+
+		if it.index+1 < len(it.page) {
+			it.index++
+
+			row := &it.page[it.index]
+
+			match, err := row.Unmarshal(it.codec)
+			if err != nil {
+				log.Printf("Unable to unmarshal match details: %d -- %s", row.Id, err.Error())
+				continue
+			}
+
+			it.current = match
+			return true
+		}
+
+		if it.done {
+			return false
+		}
+
+		it.load()
+	}
+}
+
+// Load the next page of matches.
+func (it *Iter) load() {
+	var page []model.MarshaledMatchDetail
+
+	err := it.q.Scope().Where("id > ?", it.last).Order("id").Limit(it.q.pageSize).Find(&page).Error
+	if err != nil && err != gorm.RecordNotFound {
+		it.err = err
+		return
+	}
+
+	it.page, it.index = page, -1
+
+	if len(page) < it.q.pageSize {
+		it.done = true
+	}
+
+	if n := len(page); n > 0 {
+		it.last = page[n-1].Id
+	}
+}
+
+// Match gets the current match.
+func (it *Iter) Match() *model.MatchDetail {
+	return it.current
+}
+
+// Err gets the error that stopped the iteration, if any.
+func (it *Iter) Err() error {
+	return it.err
+}
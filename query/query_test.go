@@ -0,0 +1,166 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/model"
+
+	// Import for side-effect
+	_ "github.com/jcrussell/crawlol/external/github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) gorm.DB {
+	db, err := gorm.Open("sqlite3", "sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err.Error())
+	}
+
+	// Each connection would get its own in-memory database
+	db.DB().SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&model.MarshaledMatchDetail{}, &model.MatchParticipant{}).Error; err != nil {
+		t.Fatalf("Unable to create tables: %s", err.Error())
+	}
+
+	return db
+}
+
+func TestQuery(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	day := 24 * time.Hour
+	start := time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	matches := []struct {
+		id       int64
+		queue    string
+		season   string
+		version  string
+		created  time.Time
+		duration time.Duration
+		champion int
+		summoner int64
+	}{
+		{1, "RANKED_SOLO_5x5", "SEASON2015", "5.14.0.329", start, 20 * time.Minute, 103, 3500},
+		{2, "RANKED_SOLO_5x5", "SEASON2015", "5.13.0.322", start.Add(day), 35 * time.Minute, 104, 3500},
+		{3, "RANKED_TEAM_5x5", "SEASON2015", "5.14.0.329", start.Add(2 * day), 40 * time.Minute, 103, 3501},
+		{4, "NORMAL_5x5_BLIND", "SEASON2015", "5.140.0.1", start.Add(3 * day), 25 * time.Minute, 105, 3501},
+		{5, "RANKED_SOLO_5x5", "SEASON2014", "4.21.0.300", start.Add(-200 * day), 30 * time.Minute, 103, 3502},
+	}
+
+	codec := model.NewCodec()
+
+	for _, m := range matches {
+		match := &model.MatchDetail{
+			BaseMatchDetail: model.BaseMatchDetail{
+				Id:            m.id,
+				QueueType:     m.queue,
+				Season:        m.season,
+				MatchVersion:  m.version,
+				MatchCreation: m.created.UnixNano() / int64(time.Millisecond),
+				MatchDuration: int64(m.duration / time.Second),
+			},
+			Participants: []model.Participant{{ParticipantID: 1, ChampionID: m.champion}},
+		}
+
+		row, err := match.Marshal(codec)
+		if err != nil {
+			t.Fatalf("Unable to marshal match: %s", err.Error())
+		}
+
+		db.Create(row)
+		db.Create(&model.MatchParticipant{MatchId: m.id, ChampionId: m.champion, SummonerId: m.summoner})
+	}
+
+	tests := []struct {
+		name string
+		q    *Query
+		want []int64
+	}{
+		{"all", New(db), []int64{1, 2, 3, 4, 5}},
+		{"queues", New(db).Queues("RANKED_SOLO_5x5", "RANKED_TEAM_5x5"), []int64{1, 2, 3, 5}},
+		{"seasons", New(db).Seasons("SEASON2014"), []int64{5}},
+		{"version", New(db).VersionPrefix("5.14."), []int64{1, 3}},
+		{"champion", New(db).Champion(103), []int64{1, 3, 5}},
+		{"summoner", New(db).Summoner(3501), []int64{3, 4}},
+		{"created", New(db).CreatedBetween(start, start.Add(2*day)), []int64{1, 2}},
+		{"created since", New(db).CreatedBetween(start.Add(2*day), time.Time{}), []int64{3, 4}},
+		{"duration", New(db).DurationBetween(25*time.Minute, 35*time.Minute), []int64{2, 4, 5}},
+		{"combined", New(db).Queues("RANKED_SOLO_5x5").Champion(103).Seasons("SEASON2015"), []int64{1}},
+		{"where", New(db).Where("id > ?", 3), []int64{4, 5}},
+		{"none", New(db).Champion(103).Summoner(3500).VersionPrefix("5.13."), nil},
+	}
+
+	for _, test := range tests {
+		// Small pages so that the iterator has to load several of them
+		test.q.PageSize(2)
+
+		var got []int64
+		it := test.q.Iter(codec)
+		for it.Next() {
+			got = append(got, it.Match().Id)
+
+			if it.Match().Participants[0].ChampionID == 0 {
+				t.Errorf("%s: expected match %d to be decoded, got %#v", test.name, it.Match().Id, it.Match())
+			}
+		}
+
+		if err := it.Err(); err != nil {
+			t.Errorf("%s: unable to iterate over matches: %s", test.name, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected matches %v, got %v", test.name, test.want, got)
+		}
+
+		if n, err := test.q.Count(); err != nil || n != len(test.want) {
+			t.Errorf("%s: expected count of %d, got %d (%v)", test.name, len(test.want), n, err)
+		}
+
+		if ids, err := test.q.IDs(); err != nil || !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("%s: expected IDs %v, got %v (%v)", test.name, test.want, ids, err)
+		}
+	}
+}
+
+func TestIterCorrupt(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	codec := model.NewCodec()
+
+	for _, id := range []int64{1, 3, 4} {
+		match := &model.MatchDetail{BaseMatchDetail: model.BaseMatchDetail{Id: id}}
+
+		row, err := match.Marshal(codec)
+		if err != nil {
+			t.Fatalf("Unable to marshal match: %s", err.Error())
+		}
+
+		db.Create(row)
+	}
+
+	db.Create(&model.MarshaledMatchDetail{
+		BaseMatchDetail: model.BaseMatchDetail{Id: 2},
+		Participants:    []byte("{not json"),
+	})
+
+	var got []int64
+	it := New(db).PageSize(2).Iter(codec)
+	for it.Next() {
+		got = append(got, it.Match().Id)
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatalf("Unable to iterate over matches: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(got, []int64{1, 3, 4}) {
+		t.Errorf("Expected undecodable match to be skipped, got %v", got)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+// The tables as each migration created or changed them. Migrations use these
+// rather than the live models so that what a migration does never changes,
+// even as the models gain columns in later migrations.
+
+// Version 1, the tables as they were before migrations were added.
+
+type summonerV1 struct {
+	Id            int64
+	Name          string
+	ProfileIconId int
+	RevisionDate  int64
+	SummonerLevel int64
+	LastCrawled   int64
+	ClaimedBy     string
+	ClaimExpires  int64
+}
+
+func (summonerV1) TableName() string { return "summoners" }
+
+type marshaledMatchDetailV1 struct {
+	Id                                                   int64
+	MapId                                                int
+	MatchCreation                                        int64
+	MatchDuration                                        int64
+	MatchMode                                            string
+	MatchType                                            string
+	MatchVersion                                         string
+	QueueType                                            string
+	Region                                               string
+	Season                                               string
+	ParticipantIdentities, Participants, Teams, Timeline []byte
+}
+
+func (marshaledMatchDetailV1) TableName() string { return "marshaled_match_details" }
+
+type watchedSummonerV1 struct {
+	Id         int64
+	Name       string
+	Added      int64
+	LastPolled int64
+}
+
+func (watchedSummonerV1) TableName() string { return "watched_summoners" }
+
+type failedDeliveryV1 struct {
+	Id       int64
+	MatchId  int64
+	Hook     []byte
+	Payload  []byte
+	Error    string `sql:"type:text"`
+	Attempts int
+	Failed   int64
+}
+
+func (failedDeliveryV1) TableName() string { return "failed_deliveries" }
+
+type crawlCheckpointV1 struct {
+	Id      int64
+	Start   int64
+	Pending []byte
+}
+
+func (crawlCheckpointV1) TableName() string { return "crawl_checkpoints" }
+
+type pendingSummonerV1 struct {
+	Id int64
+}
+
+func (pendingSummonerV1) TableName() string { return "pending_summoners" }
+
+type matchParticipantV1 struct {
+	Id                              int64
+	MatchId                         int64
+	ParticipantId                   int
+	SummonerId                      int64
+	SummonerName                    string
+	ChampionId                      int
+	TeamId                          int
+	Spell1Id                        int
+	Spell2Id                        int
+	Lane                            string
+	Role                            string
+	Assists                         int64
+	ChampLevel                      int64
+	CombatPlayerScore               int64
+	Deaths                          int64
+	DoubleKills                     int64
+	FirstBloodAssist                bool
+	FirstBloodKill                  bool
+	FirstInhibitorAssist            bool
+	FirstInhibitorKill              bool
+	FirstTowerAssist                bool
+	FirstTowerKill                  bool
+	GoldEarned                      int64
+	GoldSpent                       int64
+	InhibitorKills                  int64
+	Item0                           int64
+	Item1                           int64
+	Item2                           int64
+	Item3                           int64
+	Item4                           int64
+	Item5                           int64
+	Item6                           int64
+	KillingSprees                   int64
+	Kills                           int64
+	LargestCriticalStrike           int64
+	LargestKillingSpree             int64
+	LargestMultiKill                int64
+	MagicDamageDealt                int64
+	MagicDamageDealtToChampions     int64
+	MagicDamageTaken                int64
+	MinionsKilled                   int64
+	NeutralMinionsKilled            int64
+	NeutralMinionsKilledEnemyJungle int64
+	NeutralMinionsKilledTeamJungle  int64
+	NodeCapture                     int64
+	NodeCaptureAssist               int64
+	NodeNeutralize                  int64
+	NodeNeutralizeAssist            int64
+	ObjectivePlayerScore            int64
+	PentaKills                      int64
+	PhysicalDamageDealt             int64
+	PhysicalDamageDealtToChampions  int64
+	PhysicalDamageTaken             int64
+	QuadraKills                     int64
+	SightWardsBoughtInGame          int64
+	TeamObjective                   int64
+	TotalDamageDealt                int64
+	TotalDamageDealtToChampions     int64
+	TotalDamageTaken                int64
+	TotalHeal                       int64
+	TotalPlayerScore                int64
+	TotalScoreRank                  int64
+	TotalTimeCrowdControlDealt      int64
+	TotalUnitsHealed                int64
+	TowerKills                      int64
+	TripleKills                     int64
+	TrueDamageDealt                 int64
+	TrueDamageDealtToChampions      int64
+	TrueDamageTaken                 int64
+	UnrealKills                     int64
+	VisionWardsBoughtInGame         int64
+	WardsKilled                     int64
+	WardsPlaced                     int64
+	Winner                          bool
+}
+
+func (matchParticipantV1) TableName() string { return "match_participants" }
+
+type matchTeamV1 struct {
+	Id                   int64
+	MatchId              int64
+	TeamId               int
+	BaronKills           int
+	DominionVictoryScore int64
+	DragonKills          int
+	FirstBaron           bool
+	FirstBlood           bool
+	FirstDragon          bool
+	FirstInhibitor       bool
+	FirstTower           bool
+	InhibitorKills       int
+	TowerKills           int
+	VilemawKills         int
+	Winner               bool
+}
+
+func (matchTeamV1) TableName() string { return "match_teams" }
+
+type matchBanV1 struct {
+	Id         int64
+	MatchId    int64
+	TeamId     int
+	ChampionId int
+	PickTurn   int
+}
+
+func (matchBanV1) TableName() string { return "match_bans" }
+
+type matchRuneV1 struct {
+	Id            int64
+	MatchId       int64
+	ParticipantId int
+	RuneId        int64
+	Rank          int64
+}
+
+func (matchRuneV1) TableName() string { return "match_runes" }
+
+type matchMasteryV1 struct {
+	Id            int64
+	MatchId       int64
+	ParticipantId int
+	MasteryId     int64
+	Rank          int64
+}
+
+func (matchMasteryV1) TableName() string { return "match_masteries" }
+
+type matchEventV1 struct {
+	Id            int64
+	MatchId       int64
+	Sequence      int
+	Timestamp     int64
+	EventType     string
+	ParticipantId int
+	KillerId      int
+	VictimId      int
+	CreatorId     int
+	TeamId        int
+	PositionX     int
+	PositionY     int
+	ItemId        int
+	ItemBefore    int
+	ItemAfter     int
+	SkillSlot     int
+	LevelUpType   string
+	WardType      string
+	BuildingType  string
+	TowerType     string
+	LaneType      string
+	MonsterType   string
+	AscendedType  string
+	PointCaptured string
+}
+
+func (matchEventV1) TableName() string { return "match_events" }
+
+type matchEventAssistV1 struct {
+	Id            int64
+	MatchId       int64
+	Sequence      int
+	ParticipantId int
+}
+
+func (matchEventAssistV1) TableName() string { return "match_event_assists" }
+
+type matchFrameV1 struct {
+	Id                  int64
+	MatchId             int64
+	Timestamp           int64
+	ParticipantId       int
+	CurrentGold         int
+	TotalGold           int
+	Xp                  int
+	Level               int
+	MinionsKilled       int
+	JungleMinionsKilled int
+	PositionX           int
+	PositionY           int
+}
+
+func (matchFrameV1) TableName() string { return "match_frames" }
+
+// Version 3, the parts of the match blobs that the normalized tables were
+// filled from. The blobs were uncompressed JSON at the time, decoded by name.
+
+type participantIdentityV3 struct {
+	ParticipantId int
+	Player        struct {
+		SummonerId   int64
+		SummonerName string
+	}
+}
+
+type participantV3 struct {
+	ParticipantId      int
+	ChampionId         int
+	TeamId             int
+	Spell1Id, Spell2Id int
+	Timeline           struct{ Lane, Role string }
+	Stats              matchParticipantV1
+	Runes              []matchRuneV1
+	Masteries          []matchMasteryV1
+}
+
+type teamV3 struct {
+	matchTeamV1
+	Bans []matchBanV1
+}
+
+type timelineV3 struct {
+	Frames []struct {
+		Timestamp         int64
+		Events            []eventV3
+		ParticipantFrames map[string]participantFrameV3
+	}
+}
+
+type eventV3 struct {
+	matchEventV1
+	Position                struct{ X, Y int }
+	AssistingParticipantIds []int
+}
+
+type participantFrameV3 struct {
+	matchFrameV1
+	Position struct{ X, Y int }
+}
+
+// Version 4
+
+type blobDictionaryV4 struct {
+	Id      int64
+	Data    []byte
+	Created int64
+}
+
+func (blobDictionaryV4) TableName() string { return "blob_dictionaries" }
+
+// Version 5
+
+type apiResponseV5 struct {
+	Id         int64
+	Endpoint   string
+	ResourceId string
+	Url        string `sql:"type:text"`
+	Fetched    int64
+	Body       []byte
+}
+
+func (apiResponseV5) TableName() string { return "api_responses" }
+
+// Version 6, only the column that it adds.
+
+type summonerV6 struct {
+	Id        int64
+	Refreshed int64
+}
+
+func (summonerV6) TableName() string { return "summoners" }
+
+// Version 7
+
+type quarantinedMatchV7 struct {
+	Id                                                   int64
+	Reason                                               string `sql:"type:text"`
+	Quarantined                                          int64
+	ParticipantIdentities, Participants, Teams, Timeline []byte
+}
+
+func (quarantinedMatchV7) TableName() string { return "quarantined_matches" }
+
+type pendingMatchV7 struct {
+	Id int64
+}
+
+func (pendingMatchV7) TableName() string { return "pending_matches" }
+
+// Version 9
+
+type championStatV9 struct {
+	Id         int64
+	Patch      string
+	QueueType  string
+	Lane       string
+	Role       string
+	ChampionId int
+	Picks      int64
+	Wins       int64
+	Bans       int64
+}
+
+func (championStatV9) TableName() string { return "champion_stats" }
+
+type patchStatV9 struct {
+	Id        int64
+	Patch     string
+	QueueType string
+	Matches   int64
+}
+
+func (patchStatV9) TableName() string { return "patch_stats" }
+
+type aggregatedMatchV9 struct {
+	Id int64
+}
+
+func (aggregatedMatchV9) TableName() string { return "aggregated_matches" }
+
+// Version 10
+
+type crawlCheckpointV10 struct {
+	Id    int64
+	Retry []byte
+}
+
+func (crawlCheckpointV10) TableName() string { return "crawl_checkpoints" }
+
+// Version 11
+
+type pendingMatchV11 struct {
+	Id       int64
+	Attempts int
+}
+
+func (pendingMatchV11) TableName() string { return "pending_matches" }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// Seed the database with summoners from each of the sources provided on the
+// command line.
+func seedDatabase(s store, c *crawler) {
+	if *seedSummoners != "" {
+		seedFromNames(s, c, splitList(*seedSummoners))
+	}
+
+	if *seedFile != "" {
+		if err := seedFromFile(s, c, *seedFile); err != nil {
+			log.Fatalf("Unable to read seed file: %s", err.Error())
+		}
+	}
+
+	if *seedMatches {
+		if err := seedFromMatches(s, c); err != nil {
+			log.Fatalf("Unable to read summoners from stored matches: %s", err.Error())
+		}
+	}
+
+	if *seedLadder != "" {
+		seedFromLadder(s, c, *seedLadder)
+	}
+}
+
+// Seed the database with summoners looked up by name.
+func seedFromNames(s store, c *crawler, names []string) {
+	summoners, err := c.getAllSummoners(names)
+	if err != nil {
+		log.Printf("Unable to fetch seed summoners: %s", err.Error())
+	}
+
+	if err := s.upsertSummoners(summoners); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// Seed the database from a file containing one summoner per line. Lines that
+// are entirely numeric are treated as summoner IDs, all others are treated as
+// summoner names. Blank lines and lines starting with '#' are ignored.
+func seedFromFile(s store, c *crawler, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	names, ids := splitNamesAndIDs(lines)
+
+	log.Printf("Seeding %d summoner names and %d summoner IDs from %s", len(names), len(ids), path)
+
+	seedFromNames(s, c, names)
+	lookupSummoners(s, c, ids)
+
+	return nil
+}
+
+// Seed the database with the summoners that participated in the matches that
+// are already stored but that are not in the summoner table.
+func seedFromMatches(s store, c *crawler) error {
+	unknown, err := s.unknownSummoners()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Seeding %d summoners from stored matches", len(unknown))
+
+	lookupSummoners(s, c, unknown)
+
+	return nil
+}
+
+// Find the summoners that participated in stored matches but that are not in
+// the summoner table. The participants are normalized into their own indexed
+// table so the match blobs don't need to be decoded.
+func unknownSummoners(db gorm.DB) (map[int64]bool, error) {
+	participants := db.NewScope(&MatchParticipant{}).TableName()
+	summoners := db.NewScope(&Summoner{}).TableName()
+
+	rows, err := db.Raw("SELECT DISTINCT summoner_id FROM " + participants +
+		" WHERE summoner_id NOT IN (SELECT id FROM " + summoners + ")").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unknown := make(map[int64]bool)
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		unknown[id] = true
+	}
+
+	return unknown, rows.Err()
+}
+
+// Seed the database with the summoners at the top of the ladder for a queue.
+func seedFromLadder(s store, c *crawler, queue string) {
+	ids := make(map[int64]bool)
+
+	for _, url := range []string{_GetChallenger, _GetMaster} {
+		res, err := c.getLadder(url, queue)
+		if err != nil {
+			log.Printf("Unable to fetch ladder for %s: %s", queue, err.Error())
+			continue
+		}
+
+		for _, id := range res {
+			ids[id] = true
+		}
+	}
+
+	log.Printf("Seeding %d summoners from the %s ladder", len(ids), queue)
+
+	lookupSummoners(s, c, ids)
+}
+
+// Split a list of summoners into those given by name and those given by ID.
+// Values that are entirely numeric are treated as IDs.
+func splitNamesAndIDs(values []string) ([]string, map[int64]bool) {
+	var names []string
+	ids := make(map[int64]bool)
+
+	for _, v := range values {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			ids[id] = true
+		} else {
+			names = append(names, v)
+		}
+	}
+
+	return names, ids
+}
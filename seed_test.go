@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitNamesAndIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		names  []string
+		ids    map[int64]bool
+	}{
+		{"empty", nil, nil, map[int64]bool{}},
+		{"mixed", []string{"One", "2", "Three", "4"}, []string{"One", "Three"}, map[int64]bool{2: true, 4: true}},
+		{"duplicate ids", []string{"5", "5"}, nil, map[int64]bool{5: true}},
+		{"blank", []string{""}, []string{""}, map[int64]bool{}},
+		{"name with digits", []string{"Faker1", "1e3", "-"}, []string{"Faker1", "1e3", "-"}, map[int64]bool{}},
+		{"too large for an id", []string{"99999999999999999999"}, []string{"99999999999999999999"}, map[int64]bool{}},
+	}
+
+	for _, test := range tests {
+		names, ids := splitNamesAndIDs(test.values)
+		if !reflect.DeepEqual(names, test.names) || !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%s: expected %v and %v, got %v and %v", test.name, test.names, test.ids, names, ids)
+		}
+	}
+}
+
+func TestSeedFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawlol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "seed.txt")
+	if err := ioutil.WriteFile(path, []byte("# Seeds\nOne\n\n  2  \n# 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newCrawler("key", 100, 100, 1)
+	c.Client = &http.Client{Transport: fakeAPI{
+		"/api/lol/na/v1.4/summoner/by-name/One": `{"one": {"id": 1, "name": "One"}}`,
+		"/api/lol/na/v1.4/summoner/2":           `{"2": {"id": 2, "name": "Two"}}`,
+	}}
+
+	s := newMemStore()
+	if err := seedFromFile(s, c, path); err != nil {
+		t.Fatalf("Unable to seed from file: %s", err.Error())
+	}
+
+	if len(s.summoners) != 2 || s.summoners[1].Name != "One" || s.summoners[2].Name != "Two" {
+		t.Errorf("Expected summoners One and Two, got %v", s.summoners)
+	}
+
+	if err := seedFromFile(s, c, filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Expected error for missing seed file")
+	}
+}
+
+func TestSeedFromMatches(t *testing.T) {
+	c := newCrawler("key", 100, 100, 1)
+	c.Client = &http.Client{Transport: fakeAPI{
+		"/api/lol/na/v1.4/summoner/2": `{"2": {"id": 2, "name": "Two"}}`,
+	}}
+
+	match := testHookMatch(10, "RANKED_SOLO_5x5")
+	match.ParticipantIdentities = []ParticipantIdentity{
+		{ParticipantID: 1, Player: Player{SummonerID: 1}},
+		{ParticipantID: 2, Player: Player{SummonerID: 2}},
+	}
+
+	s := newMemStore()
+	s.upsertSummoners(map[string]Summoner{"one": {Id: 1, Name: "One"}})
+	s.saveMatches([]*MatchDetail{match})
+
+	if unknown, _ := s.unknownSummoners(); !reflect.DeepEqual(unknown, map[int64]bool{2: true}) {
+		t.Errorf("Expected only summoner 2 to be unknown, got %v", unknown)
+	}
+
+	if err := seedFromMatches(s, c); err != nil {
+		t.Fatalf("Unable to seed from matches: %s", err.Error())
+	}
+
+	if s.summoners[2].Name != "Two" {
+		t.Errorf("Expected summoner 2 to be looked up, got %v", s.summoners)
+	}
+}
+
+func TestUnknownSummoners(t *testing.T) {
+	match := testHookMatch(2300, "RANKED_SOLO_5x5")
+	match.ParticipantIdentities = []ParticipantIdentity{
+		{ParticipantID: 1, Player: Player{SummonerID: 3600}},
+		{ParticipantID: 2, Player: Player{SummonerID: 3601}},
+	}
+	match.Participants = append(match.Participants, Participant{ParticipantID: 2, ChampionID: 104, TeamID: 200})
+
+	if err := saveMatch(db, match); err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+	if err := db.Create(&Summoner{Id: 3600, Name: "Participant"}).Error; err != nil {
+		t.Fatalf("Unable to save summoner: %s", err.Error())
+	}
+
+	unknown, err := unknownSummoners(db)
+	if err != nil {
+		t.Fatalf("Unable to find unknown summoners: %s", err.Error())
+	}
+
+	if unknown[3600] || !unknown[3601] {
+		t.Errorf("Expected only summoner 3601 to be unknown, got %v", unknown)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+	"github.com/jcrussell/crawlol/query"
+)
+
+// ChampionStat counts how often a champion was picked, won and was banned in
+// a patch and queue. Rows with a lane or role only count the picks and wins
+// in that position, rows without either count all of them and the bans.
+type ChampionStat struct {
+	Id         int64  // Auto-incremented ID
+	Patch      string // Major and minor version of the matches, such as 5.14
+	QueueType  string // Queue type of the matches
+	Lane       string // Participant's lane, empty for all lanes
+	Role       string // Participant's role, empty for all roles
+	ChampionId int    // Champion ID
+	Picks      int64  // Number of times the champion was picked
+	Wins       int64  // Number of picks that won
+	Bans       int64  // Number of matches the champion was banned in
+}
+
+// PatchStat counts the matches in a patch and queue, the denominator for the
+// pick and ban rates.
+type PatchStat struct {
+	Id        int64  // Auto-incremented ID
+	Patch     string // Major and minor version of the matches, such as 5.14
+	QueueType string // Queue type of the matches
+	Matches   int64  // Number of matches
+}
+
+// AggregatedMatch is a match that has been counted in the stats, so that it
+// is never counted twice even if it is deleted and fetched again.
+type AggregatedMatch struct {
+	Id int64 // Match ID
+}
+
+// The patch of a match version, its major and minor version.
+func matchPatch(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Order patches by their version numbers rather than as strings, so that 5.9
+// comes before 5.10.
+func patchLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+		if errX != nil || errY != nil {
+			if as[i] != bs[i] {
+				return as[i] < bs[i]
+			}
+			continue
+		}
+
+		if x != y {
+			return x < y
+		}
+	}
+
+	return len(as) < len(bs)
+}
+
+type championKey struct {
+	patch, queue, lane, role string
+	champion                 int
+}
+
+type patchKey struct {
+	patch, queue string
+}
+
+// statCounts is the change to the stats from a batch of matches.
+type statCounts struct {
+	champions map[championKey]*ChampionStat
+	patches   map[patchKey]int64
+	ids       []int64
+}
+
+func newStatCounts() *statCounts {
+	return &statCounts{
+		champions: map[championKey]*ChampionStat{},
+		patches:   map[patchKey]int64{},
+	}
+}
+
+func (c *statCounts) champion(key championKey) *ChampionStat {
+	stat, ok := c.champions[key]
+	if !ok {
+		stat = &ChampionStat{
+			Patch:      key.patch,
+			QueueType:  key.queue,
+			Lane:       key.lane,
+			Role:       key.role,
+			ChampionId: key.champion,
+		}
+		c.champions[key] = stat
+	}
+
+	return stat
+}
+
+// Count the picks, wins and bans from a match.
+func (c *statCounts) add(match *MatchDetail) {
+	patch := matchPatch(match.MatchVersion)
+
+	c.ids = append(c.ids, match.Id)
+	c.patches[patchKey{patch, match.QueueType}]++
+
+	for _, p := range match.Participants {
+		keys := []championKey{{patch, match.QueueType, "", "", p.ChampionID}}
+		if lane, role := p.Timeline.Lane, p.Timeline.Role; lane != "" || role != "" {
+			keys = append(keys, championKey{patch, match.QueueType, lane, role, p.ChampionID})
+		}
+
+		for _, key := range keys {
+			stat := c.champion(key)
+			stat.Picks++
+			if p.Stats.Winner {
+				stat.Wins++
+			}
+		}
+	}
+
+	for _, team := range match.Teams {
+		for _, ban := range team.Bans {
+			c.champion(championKey{patch, match.QueueType, "", "", ban.ChampionID}).Bans++
+		}
+	}
+}
+
+// Add the counts to the stats and mark the matches as aggregated. Marking the
+// matches first means that if another crawler counted them in the meantime
+// the transaction fails rather than counting them twice.
+func (c *statCounts) save(tx *gorm.DB) error {
+	for _, id := range c.ids {
+		if err := tx.Create(&AggregatedMatch{Id: id}).Error; err != nil {
+			return err
+		}
+	}
+
+	patches := tx.NewScope(&PatchStat{}).TableName()
+	for key, n := range c.patches {
+		res := tx.Exec("UPDATE "+patches+" SET matches = matches + ? WHERE patch = ? AND queue_type = ?", n, key.patch, key.queue)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			if err := tx.Create(&PatchStat{Patch: key.patch, QueueType: key.queue, Matches: n}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	champions := tx.NewScope(&ChampionStat{}).TableName()
+	for _, stat := range c.champions {
+		res := tx.Exec("UPDATE "+champions+" SET picks = picks + ?, wins = wins + ?, bans = bans + ? WHERE patch = ? AND queue_type = ? AND lane = ? AND role = ? AND champion_id = ?",
+			stat.Picks, stat.Wins, stat.Bans, stat.Patch, stat.QueueType, stat.Lane, stat.Role, stat.ChampionId)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			if err := tx.Create(stat).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Count the matches that haven't been aggregated yet in the stats, in
+// transactions of up to -write-batch matches. Returns the number of matches
+// counted.
+func updateChampionStats(db gorm.DB) (int, error) {
+	aggregated := db.NewScope(&AggregatedMatch{}).TableName()
+	it := query.New(db).Where("id NOT IN (SELECT id FROM " + aggregated + ")").Iter(blobs)
+
+	var total int
+
+	flush := func(counts *statCounts) error {
+		tx := db.Begin()
+		if err := counts.save(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+
+		total += len(counts.ids)
+		return nil
+	}
+
+	counts := newStatCounts()
+	for it.Next() {
+		counts.add(it.Match())
+
+		if len(counts.ids) >= int(*writeBatch) {
+			if err := flush(counts); err != nil {
+				return total, err
+			}
+
+			counts = newStatCounts()
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return total, err
+	}
+
+	if len(counts.ids) > 0 {
+		if err := flush(counts); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// Update the stats every interval until shutdown or the crawler is done.
+func updateStatsEvery(db gorm.DB, interval time.Duration) {
+	for {
+		select {
+		case <-shutdownChan:
+			log.Println("Shutting down stats")
+			return
+		case <-crawlDone:
+			return
+		case <-time.After(interval):
+		}
+
+		if _, err := updateChampionStats(db); err != nil {
+			log.Printf("Unable to update champion stats: %s", err.Error())
+		}
+	}
+}
+
+// championRate is a champion's stats with the rates worked out.
+type championRate struct {
+	ChampionStat
+	matches  int64
+	pickRate float64
+	winRate  float64
+	banRate  float64
+	winLow   float64 // Bounds of the confidence interval for winRate
+	winHigh  float64
+}
+
+// Wilson score interval for the proportion of successes out of n at 95%
+// confidence, which behaves better than the normal approximation for small n
+// and rates near 0 or 1.
+func wilsonInterval(successes, n int64) (float64, float64) {
+	if n == 0 {
+		return 0, 0
+	}
+
+	const z = 1.96
+
+	p := float64(successes) / float64(n)
+	d := 1 + z*z/float64(n)
+	center := (p + z*z/(2*float64(n))) / d
+	margin := z * math.Sqrt(p*(1-p)/float64(n)+z*z/(4*float64(n)*float64(n))) / d
+
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+// Find the newest patch with matches in one of the queues, or in any queue if
+// no queues are given.
+func latestPatch(db gorm.DB, queues []string) (string, error) {
+	q := db.Model(&PatchStat{})
+	if len(queues) > 0 {
+		q = q.Where("queue_type IN (?)", queues)
+	}
+
+	var patches []string
+	if err := q.Pluck("DISTINCT patch", &patches).Error; err != nil && err != gorm.RecordNotFound {
+		return "", err
+	}
+
+	if len(patches) == 0 {
+		return "", errors.New("no matches have been aggregated")
+	}
+
+	sort.Sort(patchOrder(patches))
+
+	return patches[len(patches)-1], nil
+}
+
+type patchOrder []string
+
+func (p patchOrder) Len() int           { return len(p) }
+func (p patchOrder) Less(i, j int) bool { return patchLess(p[i], p[j]) }
+func (p patchOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// Work out the rates for the champions in a patch, combining the queues. With
+// byLane, the picks and wins are for each lane and role instead. Sorted by
+// pick rate, most picked first.
+func championRates(db gorm.DB, patch string, queues []string, byLane bool) ([]championRate, error) {
+	q := db.Model(&PatchStat{}).Where("patch = ?", patch)
+	if len(queues) > 0 {
+		q = q.Where("queue_type IN (?)", queues)
+	}
+
+	var matches int64
+	if err := q.Select("COALESCE(SUM(matches), 0)").Row().Scan(&matches); err != nil {
+		return nil, err
+	}
+
+	q = db.Model(&ChampionStat{}).Where("patch = ?", patch)
+	if len(queues) > 0 {
+		q = q.Where("queue_type IN (?)", queues)
+	}
+
+	if byLane {
+		q = q.Where("(lane <> '' OR role <> '')")
+	} else {
+		q = q.Where("lane = '' AND role = ''")
+	}
+
+	rows, err := q.Select("champion_id, lane, role, SUM(picks), SUM(wins), SUM(bans)").Group("champion_id, lane, role").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []championRate
+	for rows.Next() {
+		r := championRate{matches: matches}
+		if err := rows.Scan(&r.ChampionId, &r.Lane, &r.Role, &r.Picks, &r.Wins, &r.Bans); err != nil {
+			return nil, err
+		}
+
+		r.Patch = patch
+		if matches > 0 {
+			r.pickRate = float64(r.Picks) / float64(matches)
+			r.banRate = float64(r.Bans) / float64(matches)
+		}
+		if r.Picks > 0 {
+			r.winRate = float64(r.Wins) / float64(r.Picks)
+		}
+		r.winLow, r.winHigh = wilsonInterval(r.Wins, r.Picks)
+
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(byPickRate(res))
+
+	return res, nil
+}
+
+type byPickRate []championRate
+
+func (r byPickRate) Len() int      { return len(r) }
+func (r byPickRate) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byPickRate) Less(i, j int) bool {
+	if r[i].Picks != r[j].Picks {
+		return r[i].Picks > r[j].Picks
+	}
+
+	if r[i].ChampionId != r[j].ChampionId {
+		return r[i].ChampionId < r[j].ChampionId
+	}
+
+	return r[i].Lane+r[i].Role < r[j].Lane+r[j].Role
+}
+
+// Print the rates as a table, leaving out champions with fewer than minPicks
+// picks.
+func printChampionRates(w io.Writer, patch string, rates []championRate, byLane bool, minPicks int64) error {
+	var matches int64
+	if len(rates) > 0 {
+		matches = rates[0].matches
+	}
+
+	fmt.Fprintf(w, "Patch %s, %d matches\n", patch, matches)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if byLane {
+		fmt.Fprintln(tw, "CHAMPION\tLANE\tROLE\tPICKS\tPICK RATE\tWIN RATE\t95% CI")
+	} else {
+		fmt.Fprintln(tw, "CHAMPION\tPICKS\tPICK RATE\tWIN RATE\t95% CI\tBANS\tBAN RATE")
+	}
+
+	for _, r := range rates {
+		if r.Picks < minPicks {
+			continue
+		}
+
+		win := fmt.Sprintf("%.1f%%\t%.1f-%.1f%%", 100*r.winRate, 100*r.winLow, 100*r.winHigh)
+		if byLane {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%.1f%%\t%s\n", r.ChampionId, r.Lane, r.Role, r.Picks, 100*r.pickRate, win)
+		} else {
+			fmt.Fprintf(tw, "%d\t%d\t%.1f%%\t%s\t%d\t%.1f%%\n", r.ChampionId, r.Picks, 100*r.pickRate, win, r.Bans, 100*r.banRate)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// Show the aggregated stats. Usage:
+//
+//	stats champions [PATCH]
+//
+// New matches are aggregated first. Without a patch, the newest one is shown.
+func statsCommand(db gorm.DB, args []string) error {
+	if len(args) == 0 || args[0] != "champions" {
+		return errors.New("expected champions")
+	}
+
+	n, err := updateChampionStats(db)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		log.Printf("Aggregated %d new matches", n)
+	}
+
+	queues := splitList(*statsQueues)
+
+	var patch string
+	if len(args) > 1 {
+		patch = args[1]
+	} else if patch, err = latestPatch(db, queues); err != nil {
+		return err
+	}
+
+	rates, err := championRates(db, patch, queues, *statsByLane)
+	if err != nil {
+		return err
+	}
+
+	return printChampionRates(os.Stdout, patch, rates, *statsByLane, int64(*statsMinPicks))
+}
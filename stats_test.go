@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestChampionStats(t *testing.T) {
+	matches := []struct {
+		id      int64
+		version string
+		queue   string
+		winner  bool
+		lane    string
+		banned  int
+	}{
+		{2000, "8.99.0.1", "RANKED_SOLO_5x5", true, "MIDDLE", 104},
+		{2001, "8.99.0.2", "RANKED_SOLO_5x5", false, "TOP", 104},
+		{2002, "8.99.1.0", "RANKED_TEAM_5x5", true, "MIDDLE", 105},
+		{2003, "8.98.0.1", "RANKED_SOLO_5x5", true, "MIDDLE", 104},
+	}
+
+	for _, m := range matches {
+		match := testHookMatch(m.id, m.queue)
+		match.MatchVersion = m.version
+		match.Participants[0].Stats.Winner = m.winner
+		match.Participants[0].Timeline.Lane = m.lane
+		match.Participants[0].Timeline.Role = "SOLO"
+		match.Teams = []Team{
+			{TeamID: 100, Bans: []BannedChampion{{ChampionID: m.banned, PickTurn: 1}}},
+			{TeamID: 200, Bans: []BannedChampion{{ChampionID: 106, PickTurn: 2}}},
+		}
+
+		if err := saveMatch(db, match); err != nil {
+			t.Fatalf("Unable to save match: %s", err.Error())
+		}
+	}
+
+	if _, err := updateChampionStats(db); err != nil {
+		t.Fatalf("Unable to update stats: %s", err.Error())
+	}
+
+	// Matches that have already been counted shouldn't be counted again
+	if _, err := updateChampionStats(db); err != nil {
+		t.Fatalf("Unable to update stats: %s", err.Error())
+	}
+
+	rates, err := championRates(db, "8.99", []string{"RANKED_SOLO_5x5"}, false)
+	if err != nil {
+		t.Fatalf("Unable to load stats: %s", err.Error())
+	}
+
+	byChampion := map[int]championRate{}
+	for _, r := range rates {
+		byChampion[r.ChampionId] = r
+	}
+
+	if len(rates) != 3 || rates[0].ChampionId != 103 {
+		t.Fatalf("Expected 103 to be the most picked of 3 champions, got %v", rates)
+	}
+
+	if r := byChampion[103]; r.matches != 2 || r.Picks != 2 || r.Wins != 1 || r.pickRate != 1 || r.winRate != 0.5 {
+		t.Errorf("Unexpected stats for 103: %+v", r)
+	}
+
+	if r := byChampion[104]; r.Bans != 2 || r.banRate != 1 || r.Picks != 0 {
+		t.Errorf("Unexpected stats for 104: %+v", r)
+	}
+
+	// Both queues combined
+	rates, err = championRates(db, "8.99", nil, false)
+	if err != nil {
+		t.Fatalf("Unable to load stats: %s", err.Error())
+	}
+
+	if rates[0].matches != 3 || rates[0].Picks != 3 || rates[0].Wins != 2 {
+		t.Errorf("Unexpected combined stats for 103: %+v", rates[0])
+	}
+
+	rates, err = championRates(db, "8.99", nil, true)
+	if err != nil {
+		t.Fatalf("Unable to load stats: %s", err.Error())
+	}
+
+	if len(rates) != 2 || rates[0].Lane != "MIDDLE" || rates[0].Role != "SOLO" || rates[0].Picks != 2 || rates[1].Lane != "TOP" {
+		t.Errorf("Unexpected stats by lane: %+v", rates)
+	}
+}
+
+func TestPatchOrder(t *testing.T) {
+	patches := []string{"5.10", "4.21", "5.9", "5.14"}
+	sort.Sort(patchOrder(patches))
+
+	want := []string{"4.21", "5.9", "5.10", "5.14"}
+	for i := range want {
+		if patches[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, patches)
+		}
+	}
+}
+
+func TestWilsonInterval(t *testing.T) {
+	low, high := wilsonInterval(50, 100)
+	if math.Abs(low-0.4038) > 0.001 || math.Abs(high-0.5962) > 0.001 {
+		t.Errorf("Expected interval around 0.5, got %f-%f", low, high)
+	}
+
+	if low, high := wilsonInterval(0, 10); low != 0 || high <= 0 {
+		t.Errorf("Expected interval to start at 0, got %f-%f", low, high)
+	}
+}
+
+func TestUpdateStatsEveryStops(t *testing.T) {
+	defer func(done chan struct{}) { crawlDone = done }(crawlDone)
+	crawlDone = make(chan struct{})
+
+	stopped := make(chan struct{})
+	go func() {
+		updateStatsEvery(db, time.Hour)
+		close(stopped)
+	}()
+
+	// Like main once crawl returns
+	close(crawlDone)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stats loop to stop when the crawler is done")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// store is the storage that the crawler needs, so that the crawler doesn't
+// depend on gorm and can be tested without a database. Matches are saved
+// through it by the crawler, the watchlist poller, import, merge and refetch.
+// The maintenance commands, such as fsck's scans, prune, stats and export, and
+// the hooks' dead letters still work on the database directly since they only
+// make sense for SQL.
+type store interface {
+	// Check whether a match has already been saved.
+	hasMatch(id int64) bool
+	// Save a batch of matches, skipping any that are already saved. Returns
+	// the matches that were saved.
+	saveMatches(matches []*MatchDetail) ([]*MatchDetail, error)
+
+	// Claim up to n summoners that were last crawled before due, see
+	// claimSummoners.
+	nextSummoners(worker string, lease time.Duration, due int64, n int) ([]Summoner, error)
+	// Extend the lease on all the summoners claimed by the worker.
+	renewClaims(worker string, lease time.Duration)
+	// Release all the claims held by the worker.
+	releaseClaims(worker string)
+	// Record that a summoner has been crawled, removing their checkpoint and
+	// releasing the worker's claim on them.
+	markCrawled(worker string, id int64) error
+	// Save summoners that were just looked up, updating the profiles of any
+	// that are already saved while keeping their crawl state.
+	upsertSummoners(summoners map[string]Summoner) error
+	// Get up to n summoners whose profiles are due a refresh, see refreshDue.
+	refreshDue(before time.Time, n int) ([]int64, error)
+
+	// Load the checkpoint for a summoner, see loadCheckpoint.
+	loadCheckpoint(id int64) *CrawlCheckpoint
+	// Save the checkpoint, creating it if necessary.
+	saveCheckpoint(c *CrawlCheckpoint)
+
+	// Note summoners that need to be looked up, skipping any that are known.
+	addPendingSummoners(ids []int64) error
+	// Get all the summoners that need to be looked up.
+	pendingSummoners() ([]int64, error)
+	// Remove summoners that have been looked up.
+	removePendingSummoners(ids []int64) error
+	// Get the summoners in saved matches that aren't saved themselves.
+	unknownSummoners() (map[int64]bool, error)
+
+	// Queue matches to be fetched again, skipping any that are queued already.
+	addPendingMatches(ids []int64) error
+	// Get the matches that need to be fetched again, apart from the ones that
+	// have been parked, see PendingMatch.
+	pendingMatches() ([]int64, error)
+	// Record a failed attempt at fetching matches again.
+	failPendingMatches(ids []int64) error
+	// Remove matches that have been fetched again.
+	removePendingMatches(ids []int64) error
+}
+
+// sqlStore has the queries that are the same for SQLite and PostgreSQL. It is
+// embedded in the stores for each of them, which add the queries that differ.
+type sqlStore struct {
+	db gorm.DB
+}
+
+// sqliteStore keeps everything in a SQLite database.
+type sqliteStore struct {
+	sqlStore
+}
+
+// postgresStore keeps everything in a PostgreSQL database, which several
+// crawlers can share.
+type postgresStore struct {
+	sqlStore
+}
+
+// Create the store for the driver that the database was opened with.
+func newSQLStore(db gorm.DB) store {
+	if dbDriver(db) == _Postgres {
+		return &postgresStore{sqlStore{db}}
+	}
+
+	return &sqliteStore{sqlStore{db}}
+}
+
+func (s *sqliteStore) saveMatches(matches []*MatchDetail) ([]*MatchDetail, error) {
+	return insertMatches(s.db, matches)
+}
+
+// SQLite only allows one writer at a time so there's nothing to skip.
+func (s *sqliteStore) nextSummoners(worker string, lease time.Duration, due int64, n int) ([]Summoner, error) {
+	return claimSummoners(s.db, worker, lease, due, n, false)
+}
+
+func (s *postgresStore) saveMatches(matches []*MatchDetail) ([]*MatchDetail, error) {
+	return copyMatches(s.db, matches)
+}
+
+// Skip rows that another worker is in the middle of claiming rather than
+// waiting on them.
+func (s *postgresStore) nextSummoners(worker string, lease time.Duration, due int64, n int) ([]Summoner, error) {
+	return claimSummoners(s.db, worker, lease, due, n, true)
+}
+
+func (s *sqlStore) hasMatch(id int64) bool {
+	return hasMatch(s.db, id)
+}
+
+func (s *sqlStore) renewClaims(worker string, lease time.Duration) {
+	renewClaims(s.db, worker, lease)
+}
+
+func (s *sqlStore) releaseClaims(worker string) {
+	releaseClaims(s.db, worker)
+}
+
+// Update the last crawled field, remove the checkpoint and release the claim
+// together so that nobody starts over from the checkpoint.
+func (s *sqlStore) markCrawled(worker string, id int64) error {
+	tx := s.db.Begin()
+
+	err := tx.Delete(&CrawlCheckpoint{Id: id}).Error
+	if err == nil {
+		err = tx.Model(&Summoner{Id: id}).UpdateColumn("last_crawled", time.Now().UnixNano()).Error
+	}
+	if err == nil {
+		err = releaseClaim(tx, worker, id)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func (s *sqlStore) upsertSummoners(summoners map[string]Summoner) error {
+	return upsertSummoners(s.db, summoners)
+}
+
+func (s *sqlStore) refreshDue(before time.Time, n int) ([]int64, error) {
+	return refreshDue(s.db, before, n)
+}
+
+func (s *sqlStore) loadCheckpoint(id int64) *CrawlCheckpoint {
+	return loadCheckpoint(s.db, id)
+}
+
+func (s *sqlStore) saveCheckpoint(c *CrawlCheckpoint) {
+	saveCheckpoint(s.db, c)
+}
+
+func (s *sqlStore) addPendingSummoners(ids []int64) error {
+	return addPendingSummoners(s.db, ids)
+}
+
+func (s *sqlStore) pendingSummoners() ([]int64, error) {
+	var ids []int64
+	err := s.db.Model(&PendingSummoner{}).Pluck("id", &ids).Error
+
+	return ids, err
+}
+
+func (s *sqlStore) removePendingSummoners(ids []int64) error {
+	return eachChunk(ids, func(chunk []int64) error {
+		return s.db.Where("id in (?)", chunk).Delete(&PendingSummoner{}).Error
+	})
+}
+
+func (s *sqlStore) unknownSummoners() (map[int64]bool, error) {
+	return unknownSummoners(s.db)
+}
+
+func (s *sqlStore) addPendingMatches(ids []int64) error {
+	tx := s.db.Begin()
+
+	if err := addPendingMatches(tx, ids); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func (s *sqlStore) pendingMatches() ([]int64, error) {
+	var ids []int64
+	err := s.db.Model(&PendingMatch{}).Where("attempts < ?", _MaxRefetchAttempts).Pluck("id", &ids).Error
+
+	return ids, err
+}
+
+func (s *sqlStore) failPendingMatches(ids []int64) error {
+	table := s.db.NewScope(&PendingMatch{}).TableName()
+
+	return eachChunk(ids, func(chunk []int64) error {
+		return s.db.Exec("UPDATE "+table+" SET attempts = attempts + 1 WHERE id in (?)", chunk).Error
+	})
+}
+
+func (s *sqlStore) removePendingMatches(ids []int64) error {
+	return eachChunk(ids, func(chunk []int64) error {
+		return s.db.Where("id in (?)", chunk).Delete(&PendingMatch{}).Error
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStorePendingMatches(t *testing.T) {
+	stores := map[string]store{"memory": newMemStore(), "sql": newSQLStore(db)}
+
+	for name, s := range stores {
+		// Queueing a match twice only queues it once
+		if err := s.addPendingMatches([]int64{2400, 2401}); err != nil {
+			t.Fatalf("%s: unable to queue matches: %s", name, err.Error())
+		}
+		if err := s.addPendingMatches([]int64{2401, 2402, 2403}); err != nil {
+			t.Fatalf("%s: unable to queue matches: %s", name, err.Error())
+		}
+
+		if err := s.removePendingMatches([]int64{2400}); err != nil {
+			t.Fatalf("%s: unable to remove pending matches: %s", name, err.Error())
+		}
+
+		// Parked matches aren't fetched again
+		for i := 0; i < _MaxRefetchAttempts; i++ {
+			if err := s.failPendingMatches([]int64{2403}); err != nil {
+				t.Fatalf("%s: unable to record failed attempt: %s", name, err.Error())
+			}
+		}
+
+		ids, err := s.pendingMatches()
+		if err != nil {
+			t.Fatalf("%s: unable to get pending matches: %s", name, err.Error())
+		}
+
+		var got []int64
+		for _, id := range ids {
+			if id >= 2400 && id < 2500 {
+				got = append(got, id)
+			}
+		}
+		sort.Sort(int64s(got))
+
+		if !reflect.DeepEqual(got, []int64{2401, 2402}) {
+			t.Errorf("%s: expected matches 2401 and 2402 to be pending, got %v", name, got)
+		}
+
+		s.removePendingMatches([]int64{2401, 2402, 2403})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testTimeline = `{"frameInterval": 60000, "frames": [
+	{"timestamp": 0, "participantFrames": {"1": {"participantId": 1, "currentGold": 475, "totalGold": 475, "xp": 0, "level": 1}}},
+	{"timestamp": 60000,
+	 "participantFrames": {"1": {"participantId": 1, "currentGold": 120, "totalGold": 800, "xp": 250, "level": 2, "minionsKilled": 4, "position": {"x": 1000, "y": 2000}}},
+	 "events": [
+		{"eventType": "CHAMPION_KILL", "timestamp": 55000, "killerId": 1, "victimId": 6, "assistingParticipantIds": [2, 3], "position": {"x": 5000, "y": 6000}},
+		{"eventType": "ELITE_MONSTER_KILL", "timestamp": 58000, "killerId": 1, "monsterType": "DRAGON"}
+	]}
+]}`
+
+func TestTimelineRows(t *testing.T) {
+	match := testHookMatch(600, "RANKED_SOLO_5x5")
+	if err := json.Unmarshal([]byte(testTimeline), &match.Timeline); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saveMatch(db, match); err != nil {
+		t.Fatalf("Unable to save match: %s", err.Error())
+	}
+
+	var events []MatchEvent
+	db.Where("match_id = ?", 600).Order("sequence").Find(&events)
+	if len(events) != 2 || events[0].VictimId != 6 || events[0].PositionX != 5000 || events[1].Sequence != 1 {
+		t.Fatalf("Unexpected events: %#v", events)
+	}
+
+	var assists int
+	db.Model(&MatchEventAssist{}).Where("match_id = ? AND sequence = ?", 600, 0).Count(&assists)
+	if assists != 2 {
+		t.Errorf("Expected 2 assists on the kill, got %d", assists)
+	}
+
+	var frames []MatchFrame
+	db.Where("match_id = ?", 600).Order("timestamp").Find(&frames)
+	if len(frames) != 2 || frames[1].Xp != 250 || frames[1].PositionY != 2000 {
+		t.Errorf("Unexpected frames: %#v", frames)
+	}
+
+	// Timing questions are queries
+	var first int64
+	row := db.Raw("SELECT MIN(timestamp) FROM match_events WHERE match_id = ? AND monster_type = ?", 600, "DRAGON").Row()
+	if err := row.Scan(&first); err != nil || first != 58000 {
+		t.Errorf("Expected first dragon at 58000, got %d (%v)", first, err)
+	}
+}
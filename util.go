@@ -1,57 +1,32 @@
 package main
 
-import "encoding/json"
-
-// Hacky function to Marshal a MatchDetail into a MarshaledMatchDetail.
-// Marshals all the fields that aren't basic types like strings and integers.
-func (v *MatchDetail) Marshal() (*MarshaledMatchDetail, error) {
-	var buf []byte
-	var err error
-	e := MarshaledMatchDetail{(*v).BaseMatchDetail, nil, nil, nil, nil}
-
-	if buf, err = json.Marshal(v.ParticipantIdentities); err != nil {
-		return nil, err
-	}
-	e.ParticipantIdentities = buf
-
-	if buf, err = json.Marshal(v.Participants); err != nil {
-		return nil, err
+// Get the keys of a set of IDs as a slice.
+func keys(set map[int64]bool) []int64 {
+	res := make([]int64, 0, len(set))
+	for k := range set {
+		res = append(res, k)
 	}
-	e.Participants = buf
 
-	if buf, err = json.Marshal(v.Teams); err != nil {
-		return nil, err
-	}
-	e.Teams = buf
-
-	if buf, err = json.Marshal(v.Timeline); err != nil {
-		return nil, err
-	}
-	e.Timeline = buf
-
-	return &e, nil
+	return res
 }
 
-// Hacky function to Unmarshal a MarshaledMatchDetail into a MatchDetail.
-// Unmarshals all the fields that aren't basic types like strings and integers.
-func (v *MarshaledMatchDetail) Unmarshal() (*MatchDetail, error) {
-	e := MatchDetail{(*v).BaseMatchDetail, nil, nil, nil, Timeline{}}
-
-	if err := json.Unmarshal(v.ParticipantIdentities, &e.ParticipantIdentities); err != nil {
-		return nil, err
-	}
-
-	if err := json.Unmarshal(v.Participants, &e.Participants); err != nil {
-		return nil, err
-	}
+// Maximum number of IDs bound to a single "id in (?)" query. SQLite only
+// allows 999 parameters by default, so larger lists are split into chunks.
+const _MaxQueryIDs = 500
 
-	if err := json.Unmarshal(v.Teams, &e.Teams); err != nil {
-		return nil, err
-	}
+// Run fn on the IDs in chunks of up to _MaxQueryIDs, stopping at the first
+// error.
+func eachChunk(ids []int64, fn func(chunk []int64) error) error {
+	for i := 0; i < len(ids); i += _MaxQueryIDs {
+		ub := i + _MaxQueryIDs
+		if ub > len(ids) {
+			ub = len(ids)
+		}
 
-	if err := json.Unmarshal(v.Timeline, &e.Timeline); err != nil {
-		return nil, err
+		if err := fn(ids[i:ub]); err != nil {
+			return err
+		}
 	}
 
-	return &e, nil
+	return nil
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jcrussell/crawlol/external/github.com/jinzhu/gorm"
+)
+
+// WatchedSummoner is a summoner whose match history is polled at a short
+// interval instead of on the regular crawl cycle.
+type WatchedSummoner struct {
+	Id         int64  // Summoner ID
+	Name       string // Summoner name, for display only
+	Added      int64  // Time the summoner was added to the watchlist, specified as epoch nanoseconds
+	LastPolled int64  // Last time the summoner's match history was polled, specified as epoch nanoseconds
+}
+
+// watchEvent is written to the watch feed for every new match played by a
+// watched summoner.
+type watchEvent struct {
+	Time          int64  // Time the match was stored, specified as epoch nanoseconds
+	SummonerId    int64  // ID of the watched summoner
+	SummonerName  string // Name of the watched summoner
+	MatchId       int64  // ID of the match
+	QueueType     string // Match queue type
+	MatchCreation int64  // Match creation time
+	MatchDuration int64  // Match duration
+	ChampionID    int    // Champion played by the watched summoner
+	Winner        bool   // Whether the watched summoner won or not
+}
+
+// Split a rate limit so that a share of it is reserved for the watchlist.
+// Both parts are always allowed at least one request.
+func reserveRate(limit int, share float64) (rest, reserved int) {
+	reserved = int(float64(limit) * share)
+	if reserved < 1 {
+		reserved = 1
+	}
+
+	rest = limit - reserved
+	if rest < 1 {
+		rest = 1
+	}
+
+	return rest, reserved
+}
+
+// Poll the match history of the summoners on the watchlist until shutdown,
+// saving any new matches they played to s. The watchlist itself is kept in db.
+// Each new match is logged and, if feed is non-nil, written to it as a line of
+// JSON.
+func watch(s store, db gorm.DB, c *crawler, filter *matchFilter, feed io.Writer) {
+	for {
+		// Reload the watchlist each time around so that changes are picked up
+		// without a restart.
+		var watched []WatchedSummoner
+		if err := db.Find(&watched).Error; err != nil && err != gorm.RecordNotFound {
+			log.Printf("Unable to load watchlist: %s", err.Error())
+		}
+
+		for _, summoner := range watched {
+			select {
+			case <-shutdownChan:
+				log.Println("Shutting down watcher")
+				return
+			default:
+			}
+
+			pollSummoner(s, db, c, filter, feed, summoner)
+		}
+
+		select {
+		case <-shutdownChan:
+			log.Println("Shutting down watcher")
+			return
+		case <-time.After(*watchInterval):
+		}
+	}
+}
+
+// Check the most recent page of a watched summoner's match history for
+// matches that we haven't stored yet. New matches are saved the same way as
+// crawled ones, so the summoners in them are queued to be crawled too.
+func pollSummoner(s store, db gorm.DB, c *crawler, filter *matchFilter, feed io.Writer, summoner WatchedSummoner) {
+	matches, err := c.getMatchHistory(summoner.Id, 0)
+	if err != nil {
+		log.Printf("Unable to fetch recent matches for watched summoner: %s -- %s", summoner.Name, err.Error())
+		return
+	}
+
+	w := newMatchWriter(s, int(*writeBatch))
+	w.queueSummoners = true
+
+	var found []*MatchDetail
+	for _, match := range matches {
+		if !filter.allows(&match) {
+			continue
+		}
+
+		if s.hasMatch(match.Id) || w.has(match.Id) {
+			continue
+		}
+
+		details, err := c.getMatch(match.Id)
+		if err != nil {
+			log.Printf("Unable to fetch match details: %d -- %s", match.Id, err.Error())
+			continue
+		}
+
+		if !filter.allows(&details.BaseMatchDetail) {
+			continue
+		}
+
+		if err := w.add(details); err != nil {
+			log.Print(err.Error())
+		}
+
+		found = append(found, details)
+	}
+
+	if err := w.flush(); err != nil {
+		log.Print(err.Error())
+	}
+
+	// Only report the matches that were saved, the others are picked up again
+	// on the next poll
+	for _, details := range found {
+		if !containsInt64(w.failed, details.Id) {
+			reportMatch(feed, summoner, details)
+		}
+	}
+
+	summoner.LastPolled = time.Now().UnixNano()
+	if err := db.Save(&summoner).Error; err != nil {
+		log.Printf("Unable to update last polled for watched summoner: %d -- %s", summoner.Id, err.Error())
+	}
+}
+
+// Log a new match for a watched summoner and write it to the feed.
+func reportMatch(feed io.Writer, summoner WatchedSummoner, match *MatchDetail) {
+	event := watchEvent{
+		Time:          time.Now().UnixNano(),
+		SummonerId:    summoner.Id,
+		SummonerName:  summoner.Name,
+		MatchId:       match.Id,
+		QueueType:     match.QueueType,
+		MatchCreation: match.MatchCreation,
+		MatchDuration: match.MatchDuration,
+	}
+
+	// Find the participant that is the watched summoner to fill in how their
+	// match went.
+	for _, identity := range match.ParticipantIdentities {
+		if identity.Player.SummonerID != summoner.Id {
+			continue
+		}
+
+		for _, participant := range match.Participants {
+			if participant.ParticipantID == identity.ParticipantID {
+				event.ChampionID = participant.ChampionID
+				event.Winner = participant.Stats.Winner
+			}
+		}
+	}
+
+	log.Printf("Watched summoner %s played match %d (%s, champion %d, winner %t)",
+		summoner.Name, match.Id, match.QueueType, event.ChampionID, event.Winner)
+
+	if feed == nil {
+		return
+	}
+
+	buf, err := json.Marshal(&event)
+	if err != nil {
+		log.Printf("Unable to marshal watch event: %s", err.Error())
+		return
+	}
+
+	if _, err := feed.Write(append(buf, '\n')); err != nil {
+		log.Printf("Unable to write to watch feed: %s", err.Error())
+	}
+}
+
+// Manage the watchlist. Usage:
+//
+//	watch add NAME|ID...
+//	watch remove NAME|ID...
+//	watch list
+func watchCommand(db gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected one of add, remove or list")
+	}
+
+	switch args[0] {
+	case "add":
+		return watchAdd(db, args[1:])
+	case "remove":
+		return watchRemove(db, args[1:])
+	case "list":
+		return watchList(db, os.Stdout)
+	}
+
+	return fmt.Errorf("unknown watch command: %s", args[0])
+}
+
+// Add summoners to the watchlist, looking them up with the API so that they
+// are also known to the crawler.
+func watchAdd(db gorm.DB, args []string) error {
+	c, err := newAPICrawler()
+	if err != nil {
+		return err
+	}
+
+	names, ids := splitNamesAndIDs(args)
+
+	summoners, err := c.getAllSummoners(names)
+	if err != nil {
+		return err
+	}
+
+	byID, err := c.getAllSummonersByID(keys(ids))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range byID {
+		summoners[k] = v
+	}
+
+	if err := upsertSummoners(db, summoners); err != nil {
+		return err
+	}
+
+	for _, summoner := range summoners {
+		watched := WatchedSummoner{
+			Id:    summoner.Id,
+			Name:  summoner.Name,
+			Added: time.Now().UnixNano(),
+		}
+
+		// Summoners that are already on the watchlist are left alone
+		if !db.First(&WatchedSummoner{}, summoner.Id).RecordNotFound() {
+			continue
+		}
+
+		if err := db.Create(&watched).Error; err != nil {
+			return err
+		}
+
+		log.Printf("Watching summoner: %s (%d)", summoner.Name, summoner.Id)
+	}
+
+	if len(summoners) != len(args) {
+		log.Printf("Found %d of %d summoners", len(summoners), len(args))
+	}
+
+	return nil
+}
+
+// Remove summoners from the watchlist by name or ID.
+func watchRemove(db gorm.DB, args []string) error {
+	for _, arg := range args {
+		id, _ := strconv.ParseInt(arg, 10, 64)
+
+		res := db.Where("id = ? OR name = ?", id, arg).Delete(&WatchedSummoner{})
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			log.Printf("Summoner is not on the watchlist: %s", arg)
+		}
+	}
+
+	return nil
+}
+
+// Print the watchlist.
+func watchList(db gorm.DB, w io.Writer) error {
+	var watched []WatchedSummoner
+	if err := db.Order("name asc").Find(&watched).Error; err != nil && err != gorm.RecordNotFound {
+		return err
+	}
+
+	for _, summoner := range watched {
+		lastPolled := "never"
+		if summoner.LastPolled != 0 {
+			lastPolled = time.Unix(0, summoner.LastPolled).Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\tlast polled %s\n", summoner.Id, summoner.Name, lastPolled)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestReserveRate(t *testing.T) {
+	tests := []struct {
+		limit          int
+		share          float64
+		rest, reserved int
+	}{
+		{10, 0.2, 8, 2},
+		{500, 0.2, 400, 100},
+		{2, 0.1, 1, 1},
+		{1, 0.5, 1, 1},
+	}
+
+	for _, test := range tests {
+		rest, reserved := reserveRate(test.limit, test.share)
+		if rest != test.rest || reserved != test.reserved {
+			t.Errorf("reserveRate(%d, %f) = (%d, %d), expected (%d, %d)",
+				test.limit, test.share, rest, reserved, test.rest, test.reserved)
+		}
+	}
+}
+
+func TestReportMatch(t *testing.T) {
+	match := &MatchDetail{
+		ParticipantIdentities: []ParticipantIdentity{
+			{ParticipantID: 1, Player: Player{SummonerID: 10}},
+			{ParticipantID: 2, Player: Player{SummonerID: 20}},
+		},
+		Participants: []Participant{
+			{ParticipantID: 1, ChampionID: 103},
+			{ParticipantID: 2, ChampionID: 222, Stats: ParticipantStats{Winner: true}},
+		},
+	}
+	match.Id = 1234
+	match.QueueType = "RANKED_SOLO_5x5"
+
+	var buf bytes.Buffer
+	reportMatch(&buf, WatchedSummoner{Id: 20, Name: "Watched"}, match)
+
+	var event watchEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Unable to unmarshal watch event: %s", err.Error())
+	}
+
+	if event.MatchId != 1234 || event.SummonerId != 20 || event.ChampionID != 222 || !event.Winner {
+		t.Errorf("Unexpected watch event: %#v", event)
+	}
+}
+
+func TestWatchRemove(t *testing.T) {
+	for _, summoner := range []WatchedSummoner{{Id: 1, Name: "One"}, {Id: 2, Name: "Two"}, {Id: 3, Name: "Three"}} {
+		if err := db.Create(&summoner).Error; err != nil {
+			t.Fatalf("Unable to create watched summoner: %s", err.Error())
+		}
+	}
+
+	if err := watchRemove(db, []string{"One", "3"}); err != nil {
+		t.Fatalf("Unable to remove watched summoners: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := watchList(db, &buf); err != nil {
+		t.Fatalf("Unable to list watched summoners: %s", err.Error())
+	}
+
+	if got := buf.String(); got != "2\tTwo\tlast polled never\n" {
+		t.Errorf("Unexpected watchlist: %q", got)
+	}
+}
+
+func TestPollSummoner(t *testing.T) {
+	api := fakeAPI{
+		"/api/lol/na/v2.2/matchhistory/3602?beginIndex=0": `{"matches": [{"matchId": 2300}, {"matchId": 2301}]}`,
+		"/api/lol/na/v2.2/match/2300": `{"matchId": 2300, "participantIdentities": [
+			{"participantId": 1, "player": {"summonerId": 3602}}, {"participantId": 2, "player": {"summonerId": 3603}}]}`,
+		"/api/lol/na/v2.2/match/2301": `{"matchId": 2301, "participantIdentities": [
+			{"participantId": 1, "player": {"summonerId": 3602}}, {"participantId": 2, "player": {"summonerId": 3604}}]}`,
+	}
+
+	fake := newCrawler("key", 100, 100, 1)
+	fake.Client = &http.Client{Transport: api}
+
+	s := &badStore{newMemStore(), 2301}
+
+	var buf bytes.Buffer
+	pollSummoner(s, db, fake, nil, &buf, WatchedSummoner{Id: 3602, Name: "Polled"})
+
+	if !s.hasMatch(2300) || s.hasMatch(2301) {
+		t.Error("Expected only match 2300 to be saved")
+	}
+
+	// The summoners are only queued from the match that was saved
+	if !s.pending[3603] || s.pending[3604] {
+		t.Errorf("Expected only summoner 3603 to be queued, got %v", s.pending)
+	}
+
+	var event watchEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil || event.MatchId != 2300 {
+		t.Errorf("Expected only match 2300 to be reported, got %q", buf.String())
+	}
+}